@@ -26,7 +26,16 @@ for OS in ${BUILD_OSS[@]} ; do
             NAME="${NAME}.exe"
         fi
 
-        GOOS=$OS GOARCH=$ARCH go build -ldflags "-X main.version=${BUILD_VER}" \
+        # SQLite needs cgo, which can't cross-compile without a C toolchain for the target, so
+        # only the build for this machine can use `format -f sqlite` and `archive`.
+        CGO=0
+        if [[ ${OS} == "$(go env GOHOSTOS)" && ${ARCH} == "$(go env GOHOSTARCH)" ]] ; then
+            CGO=1
+        else
+            echo "  warning: built without cgo, so \`format -f sqlite\` and \`archive\` won't work" >&2
+        fi
+
+        CGO_ENABLED=$CGO GOOS=$OS GOARCH=$ARCH go build -tags sqlite_fts5 -ldflags "-X main.version=${BUILD_VER}" \
         -o "${BUILD_ROOT}/release/${NAME}" .
         shasum -a 256 "${BUILD_ROOT}/release/${NAME}" > "${BUILD_ROOT}/release/${NAME}.sha256"
     done
@@ -61,10 +61,18 @@ func AnalyseTables(bf *types.BackupFile) (map[string]int, error) {
 			counts["avatar"]++
 			continue
 		}
+		if f.GetSticker() != nil {
+			counts["sticker"]++
+			continue
+		}
 		if f.GetPreference() != nil {
 			counts["pref"]++
 			continue
 		}
+		if f.GetKeyValue() != nil {
+			counts["key_value"]++
+			continue
+		}
 		if stmt := f.GetStatement(); stmt != nil {
 			if strings.HasPrefix(*stmt.Statement, "DROP TABLE") {
 				if counts["drop_table"] == 0 {
@@ -0,0 +1,589 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+// Archive fulfils the `archive` subcommand.
+var Archive = cli.Command{
+	Name:  "archive",
+	Usage: "Keep a long-term archive of many backups",
+	UsageText: "Maintain a SQLite database with the messages and attachments of every backup added to it.\n" +
+		"This needs a build with cgo, as --format sqlite does.",
+	CustomHelpTemplate: SubcommandHelp,
+	Subcommands: []cli.Command{
+		{
+			Name:  "ingest",
+			Usage: "Add a backup to the archive",
+			UsageText: "Add the recipients, threads, messages and attachments in the backup to the archive,\n" +
+				"creating it if it doesn't exist. Nothing already in the archive is removed.",
+			CustomHelpTemplate: SubcommandHelp,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "use the archive database at `FILE`",
+				},
+			}, coreFlags...),
+			Action: func(c *cli.Context) error {
+				if c.String("db") == "" {
+					return errors.New("must specify an archive database with --db")
+				}
+				if err := requireSQLite(); err != nil {
+					return err
+				}
+
+				bf, err := setup(c)
+				if err != nil {
+					return err
+				}
+
+				path, err := filepath.Abs(c.Args().Get(0))
+				if err != nil {
+					path = c.Args().Get(0)
+				}
+				if err = Ingest(bf, path, c.String("db")); err != nil {
+					return errors.Wrap(err, "failed to ingest backup")
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// ArchiveSchemaVersion is the version of the archive database's schema, stored in its
+// `user_version`. It is bumped whenever the schema changes.
+const ArchiveSchemaVersion = 2
+
+// archiveSchema creates the first version of the archive database, which archiveMigrations then
+// bring up to date. Unlike the SQLite format, the schema is the same whichever version of Signal a
+// backup came from.
+//
+// Every row records the first and last backup it was seen in. Rows are never removed, so
+// anything that was in any backup stays in the archive.
+const archiveSchema = `
+CREATE TABLE backups (
+	id          INTEGER PRIMARY KEY,
+	salt        TEXT NOT NULL UNIQUE,
+	path        TEXT NOT NULL,
+	ingested_at INTEGER NOT NULL
+);
+
+CREATE TABLE recipients (
+	id           INTEGER PRIMARY KEY,
+	address      TEXT NOT NULL UNIQUE,
+	first_backup INTEGER NOT NULL REFERENCES backups (id),
+	last_backup  INTEGER NOT NULL REFERENCES backups (id)
+);
+
+CREATE TABLE threads (
+	id           INTEGER PRIMARY KEY,
+	recipient_id INTEGER NOT NULL UNIQUE REFERENCES recipients (id),
+	first_backup INTEGER NOT NULL REFERENCES backups (id),
+	last_backup  INTEGER NOT NULL REFERENCES backups (id)
+);
+
+CREATE TABLE messages (
+	id            INTEGER PRIMARY KEY,
+	kind          TEXT NOT NULL,
+	date_sent     INTEGER NOT NULL,
+	recipient_id  INTEGER NOT NULL REFERENCES recipients (id),
+	thread_id     INTEGER REFERENCES threads (id),
+	date_received INTEGER,
+	type          INTEGER NOT NULL,
+	direction     TEXT NOT NULL,
+	read          INTEGER NOT NULL,
+	body          TEXT,
+	expires_in    INTEGER NOT NULL,
+	first_backup  INTEGER NOT NULL REFERENCES backups (id),
+	last_backup   INTEGER NOT NULL REFERENCES backups (id),
+	UNIQUE (kind, date_sent, recipient_id)
+);
+
+CREATE TABLE blobs (
+	sha256 TEXT PRIMARY KEY,
+	size   INTEGER NOT NULL,
+	data   BLOB NOT NULL
+);
+
+CREATE TABLE parts (
+	id           INTEGER PRIMARY KEY,
+	message_id   INTEGER NOT NULL REFERENCES messages (id),
+	unique_id    INTEGER NOT NULL,
+	seq          INTEGER NOT NULL,
+	content_type TEXT,
+	file_name    TEXT,
+	size         INTEGER,
+	width        INTEGER NOT NULL,
+	height       INTEGER NOT NULL,
+	voice_note   INTEGER NOT NULL,
+	caption      TEXT,
+	blob         TEXT REFERENCES blobs (sha256),
+	first_backup INTEGER NOT NULL REFERENCES backups (id),
+	last_backup  INTEGER NOT NULL REFERENCES backups (id),
+	UNIQUE (message_id, unique_id)
+);
+
+CREATE INDEX messages_thread_id ON messages (thread_id, date_sent);
+CREATE INDEX parts_blob ON parts (blob);
+`
+
+// archiveMigrations upgrade an archive from the version they're keyed by to the next one.
+var archiveMigrations = map[int]string{
+	// Newer versions of Signal refer to recipients by the ID of their row in its recipient table,
+	// which differs between devices, so recipients are identified by what that table says instead.
+	// address is kept for what older versions recorded in place of a recipient.
+	1: `
+ALTER TABLE recipients ADD COLUMN uuid TEXT;
+ALTER TABLE recipients ADD COLUMN phone TEXT;
+ALTER TABLE recipients ADD COLUMN group_id TEXT;
+ALTER TABLE recipients ADD COLUMN email TEXT;
+
+CREATE INDEX recipients_uuid ON recipients (uuid);
+CREATE INDEX recipients_phone ON recipients (phone);
+CREATE INDEX recipients_group_id ON recipients (group_id);
+CREATE INDEX recipients_email ON recipients (email);
+`,
+}
+
+// Ingest adds a backup to the archive database at dbPath, creating the database if it doesn't
+// exist. path is recorded as where the backup came from.
+func Ingest(bf *types.BackupFile, path, dbPath string) error {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to open archive")
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if err = migrateArchive(tx); err != nil {
+		return err
+	}
+
+	salt := hex.EncodeToString(bf.Salt)
+	var existing int64
+	err = tx.QueryRow("SELECT id FROM backups WHERE salt = ?", salt).Scan(&existing)
+	if err == nil {
+		log.Printf("%s is already in the archive\n", path)
+		return nil
+	} else if err != sql.ErrNoRows {
+		return errors.Wrap(err, "unable to read archive")
+	}
+
+	res, err := tx.Exec("INSERT INTO backups (salt, path, ingested_at) VALUES (?, ?, ?)",
+		salt, path, time.Now().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return errors.Wrap(err, "unable to add backup")
+	}
+	backup, err := res.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "unable to add backup")
+	}
+
+	a := &archiver{
+		tx:         tx,
+		backup:     backup,
+		salt:       salt,
+		addresses:  map[string]int64{},
+		recipients: map[uint64]int64{},
+		partRows:   map[uint64]bool{},
+		blobs:      map[uint64]string{},
+		threadIDs:  map[uint64]int64{},
+		mms:        map[uint64]int64{},
+	}
+
+	discard := types.DiscardConsumeFuncs(bf)
+	err = bf.Consume(types.ConsumeFuncs{
+		StatementFunc: func(stmt *signal.SqlStatement) error {
+			if !isInsert(stmt) {
+				return nil
+			}
+			r, err := bf.Schema.Row(stmt)
+			if err != nil {
+				return err
+			}
+			return a.row(r)
+		},
+		AttachmentFunc: func(att *signal.Attachment) error {
+			if !a.partRows[att.GetRowId()] {
+				return discard.AttachmentFunc(att)
+			}
+			return a.attachment(bf, att)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = a.finish(); err != nil {
+		return err
+	}
+
+	log.Printf("archived %d messages and %d attachments\n", a.messageCount, a.attachments)
+	return errors.Wrap(tx.Commit(), "unable to commit archive")
+}
+
+func migrateArchive(tx *sql.Tx) error {
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "unable to read archive version")
+	}
+
+	switch {
+	case version == ArchiveSchemaVersion:
+		return nil
+	case version > ArchiveSchemaVersion:
+		return errors.Errorf("archive version %d is newer than %d", version, ArchiveSchemaVersion)
+	}
+
+	if version == 0 {
+		if _, err := tx.Exec(archiveSchema); err != nil {
+			return errors.Wrap(err, "unable to create archive")
+		}
+		version = 1
+	}
+	for ; version < ArchiveSchemaVersion; version++ {
+		if _, err := tx.Exec(archiveMigrations[version]); err != nil {
+			return errors.Wrapf(err, "unable to upgrade archive from version %d", version)
+		}
+	}
+	_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", ArchiveSchemaVersion))
+	return errors.Wrap(err, "unable to upgrade archive")
+}
+
+// archiver adds the rows of a single backup to the archive.
+//
+// Signal writes its recipient table after the threads and messages that refer to it, so
+// recipients are added as they're read, and everything else once the whole backup has been.
+type archiver struct {
+	tx     *sql.Tx
+	backup int64
+	salt   string
+
+	// Archive IDs of recipients, by the address older versions of Signal used for them, and by the
+	// ID of their row in the backup's recipient table.
+	addresses  map[string]int64
+	recipients map[uint64]int64
+
+	threads  []pendingThread
+	messages []pendingMessage
+	parts    []*types.SQLPart
+	// The row IDs of the parts, and the SHA-256 of the data stored for each of them.
+	partRows map[uint64]bool
+	blobs    map[uint64]string
+
+	// Archive IDs of the backup's threads and mms, by their IDs in the backup.
+	threadIDs map[uint64]int64
+	mms       map[uint64]int64
+
+	messageCount int
+	attachments  int
+}
+
+type pendingThread struct {
+	id        uint64
+	recipient string
+}
+
+type pendingMessage struct {
+	message types.JSONMessage
+	address string
+}
+
+func (a *archiver) row(r *types.Row) error {
+	switch r.Table {
+	case "recipient":
+		return a.recipientRow(r)
+	case "thread":
+		if recipient := threadRecipient(r); recipient != "" {
+			a.threads = append(a.threads, pendingThread{id: r.Param("_id").GetIntegerParameter(), recipient: recipient})
+		}
+	case "sms":
+		sms, err := types.RowToSMS(r)
+		if err != nil {
+			return err
+		}
+		a.messages = append(a.messages, pendingMessage{types.NewJSONSMS(sms), paramString(r.Param("address"))})
+	case "mms":
+		mms, err := types.RowToMMS(r)
+		if err != nil {
+			return err
+		}
+		a.messages = append(a.messages, pendingMessage{types.NewJSONMMS(mms), paramString(r.Param("address"))})
+	case "part":
+		part, err := types.RowToPart(r)
+		if err != nil {
+			return err
+		}
+		if part.MmsID != nil {
+			a.parts = append(a.parts, part)
+			a.partRows[part.RowID] = true
+		}
+	}
+	return nil
+}
+
+// recipientRow adds a row of the backup's recipient table.
+func (a *archiver) recipientRow(r *types.Row) error {
+	id := r.Param("_id").GetIntegerParameter()
+	keys := map[string]string{}
+	var address string
+	for _, column := range recipientColumns {
+		if v := r.String(column); v != nil && *v != "" {
+			keys[column] = *v
+			if address == "" {
+				address = *v
+			}
+		}
+	}
+	// Nothing identifies the recipient outside this backup, but it's still kept apart from the
+	// others.
+	if address == "" {
+		address = fmt.Sprintf("recipient %d of backup %s", id, a.salt)
+	}
+
+	recipient, err := a.recipient(address, keys)
+	if err != nil {
+		return err
+	}
+	a.recipients[id] = recipient
+	return nil
+}
+
+// addressRecipient returns the archive ID of the recipient a thread or message refers to, which is
+// either the ID of a row in the backup's recipient table or, in older versions of Signal, an
+// address.
+func (a *archiver) addressRecipient(address string) (int64, error) {
+	if n, err := strconv.ParseUint(address, 10, 64); err == nil {
+		if id, ok := a.recipients[n]; ok {
+			return id, nil
+		}
+	}
+	if id, ok := a.addresses[address]; ok {
+		return id, nil
+	}
+
+	id, err := a.recipient(address, nil)
+	if err != nil {
+		return 0, err
+	}
+	a.addresses[address] = id
+	return id, nil
+}
+
+// recipient returns the archive ID of a recipient, adding it if it's new. A recipient from the
+// recipient table is identified by keys, by column, and matches any recipient that shares one of
+// them, or whose address is one of them. One known only by its address matches on any of them.
+func (a *archiver) recipient(address string, keys map[string]string) (int64, error) {
+	conds := []string{"address = ?"}
+	args := []interface{}{address}
+	if len(keys) == 0 {
+		for _, column := range recipientColumns[1:] {
+			conds = append(conds, column+" = ?")
+			args = append(args, address)
+		}
+	}
+	for _, column := range recipientColumns {
+		if v, ok := keys[column]; ok {
+			conds = append(conds, column+" = ?", "address = ?")
+			args = append(args, v, v)
+		}
+	}
+
+	values := make([]interface{}, len(recipientColumns))
+	for i, column := range recipientColumns {
+		if v, ok := keys[column]; ok {
+			values[i] = v
+		}
+	}
+
+	var id int64
+	err := a.tx.QueryRow("SELECT id FROM recipients WHERE "+strings.Join(conds, " OR ")+" ORDER BY id LIMIT 1", args...).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		res, err := a.tx.Exec(`INSERT INTO recipients (address, uuid, phone, group_id, email, first_backup, last_backup)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			append(append([]interface{}{address}, values...), a.backup, a.backup)...)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to add recipient")
+		}
+		id, err = res.LastInsertId()
+		return id, errors.Wrap(err, "unable to add recipient")
+	case err != nil:
+		return 0, errors.Wrap(err, "unable to read recipient")
+	}
+
+	_, err = a.tx.Exec(`UPDATE recipients SET uuid = COALESCE(?, uuid), phone = COALESCE(?, phone),
+			group_id = COALESCE(?, group_id), email = COALESCE(?, email), last_backup = ?
+		WHERE id = ?`,
+		append(values, a.backup, id)...)
+	return id, errors.Wrap(err, "unable to update recipient")
+}
+
+// finish adds the threads, messages and parts of the backup, now that all of its recipients are
+// known.
+func (a *archiver) finish() error {
+	for _, t := range a.threads {
+		if err := a.thread(t); err != nil {
+			return err
+		}
+	}
+	for _, m := range a.messages {
+		if err := a.message(m); err != nil {
+			return err
+		}
+	}
+	for _, p := range a.parts {
+		if err := a.part(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// thread adds a thread, identified by its recipients.
+func (a *archiver) thread(t pendingThread) error {
+	recipient, err := a.addressRecipient(t.recipient)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.tx.Exec(`INSERT INTO threads (recipient_id, first_backup, last_backup) VALUES (?, ?, ?)
+		ON CONFLICT (recipient_id) DO UPDATE SET last_backup = excluded.last_backup`,
+		recipient, a.backup, a.backup)
+	if err != nil {
+		return errors.Wrap(err, "unable to add thread")
+	}
+
+	var id int64
+	if err = a.tx.QueryRow("SELECT id FROM threads WHERE recipient_id = ?", recipient).Scan(&id); err != nil {
+		return errors.Wrap(err, "unable to add thread")
+	}
+	a.threadIDs[t.id] = id
+	return nil
+}
+
+// message adds a message, identified by its kind, when it was sent and who it was sent to or from.
+func (a *archiver) message(pm pendingMessage) error {
+	m := pm.message
+	recipient, err := a.addressRecipient(pm.address)
+	if err != nil {
+		return err
+	}
+	var thread interface{}
+	if id, ok := a.threadIDs[m.ThreadID]; ok {
+		thread = id
+	}
+
+	_, err = a.tx.Exec(`INSERT INTO messages (kind, date_sent, recipient_id, thread_id, date_received, type,
+			direction, read, body, expires_in, first_backup, last_backup)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, date_sent, recipient_id) DO UPDATE SET
+			thread_id = COALESCE(excluded.thread_id, thread_id),
+			date_received = excluded.date_received,
+			type = excluded.type,
+			direction = excluded.direction,
+			read = excluded.read,
+			body = excluded.body,
+			expires_in = excluded.expires_in,
+			last_backup = excluded.last_backup`,
+		m.Kind, int64(derefUint64(m.DateSent)), recipient, thread, nullUint64(m.DateReceived), int64(m.Type),
+		m.Direction, m.Read, m.Body, int64(m.ExpiresIn), a.backup, a.backup)
+	if err != nil {
+		return errors.Wrap(err, "unable to add message")
+	}
+
+	var id int64
+	err = a.tx.QueryRow("SELECT id FROM messages WHERE kind = ? AND date_sent = ? AND recipient_id = ?",
+		m.Kind, int64(derefUint64(m.DateSent)), recipient).Scan(&id)
+	if err != nil {
+		return errors.Wrap(err, "unable to add message")
+	}
+
+	if m.Kind == types.JSONKindMMS {
+		a.mms[m.ID] = id
+	}
+	a.messageCount++
+	return nil
+}
+
+// part adds a part of an mms that has already been added, along with the attachment stored for it.
+func (a *archiver) part(p *types.SQLPart) error {
+	message, ok := a.mms[*p.MmsID]
+	if !ok {
+		return nil
+	}
+	var blob interface{}
+	if hash, ok := a.blobs[p.RowID]; ok {
+		blob = hash
+	}
+
+	_, err := a.tx.Exec(`INSERT INTO parts (message_id, unique_id, seq, content_type, file_name, size, width,
+			height, voice_note, caption, blob, first_backup, last_backup)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, unique_id) DO UPDATE SET
+			seq = excluded.seq,
+			content_type = excluded.content_type,
+			file_name = excluded.file_name,
+			size = excluded.size,
+			width = excluded.width,
+			height = excluded.height,
+			voice_note = excluded.voice_note,
+			caption = excluded.caption,
+			blob = COALESCE(excluded.blob, blob),
+			last_backup = excluded.last_backup`,
+		message, int64(p.UniqueID), int64(p.Seq), p.ContentType, p.FileName, nullUint64(p.Size), int64(p.Width),
+		int64(p.Height), p.VoiceNote != 0, p.Caption, blob, a.backup, a.backup)
+	return errors.Wrap(err, "unable to add part")
+}
+
+// attachment stores the data of a part, keyed by its SHA-256 so that each attachment is only stored
+// once however many backups or messages it's in.
+func (a *archiver) attachment(bf *types.BackupFile, att *signal.Attachment) error {
+	var buf bytes.Buffer
+	if err := bf.DecryptAttachment(att.GetLength(), &buf); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	hash := hex.EncodeToString(sum[:])
+
+	_, err := a.tx.Exec("INSERT OR IGNORE INTO blobs (sha256, size, data) VALUES (?, ?, ?)", hash, buf.Len(), buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "unable to add attachment")
+	}
+	a.blobs[att.GetRowId()] = hash
+	a.attachments++
+	return nil
+}
+
+func nullUint64(n *uint64) interface{} {
+	if n == nil {
+		return nil
+	}
+	return int64(*n)
+}
+
+func derefUint64(n *uint64) uint64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
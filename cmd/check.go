@@ -15,16 +15,11 @@ var Check = cli.Command{
 	Usage:              "Verify that a backup is readable",
 	UsageText:          "Attempts to decrypt the provided backup and do nothing with it except verify that it's readable\n from start to finish. Enables verbose logging by default.",
 	CustomHelpTemplate: SubcommandHelp,
-	Flags: []cli.Flag{
-		cli.StringFlag{
-			Name:  "password, p",
-			Usage: "use `PASS` as password for backup file",
-		},
-		cli.StringFlag{
-			Name:  "pwdfile, P",
-			Usage: "read password from `FILE`",
-		},
-	},
+	Flags: append(passwordFlags,
+		keyfileFlag,
+		memberFlag,
+		progressFlag,
+	),
 	Action: func(c *cli.Context) error {
 		bf, err := setup(c)
 		if err != nil {
@@ -32,6 +27,7 @@ var Check = cli.Command{
 		}
 
 		log.SetOutput(os.Stderr)
+		showProgress(c, bf, true)
 
 		if err := Raw(bf, ioutil.Discard); err != nil {
 			return errors.Wrap(err, "Encountered error while checking")
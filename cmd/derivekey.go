@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/types"
+)
+
+var keyfileFlag = cli.StringFlag{
+	Name:  "keyfile",
+	Usage: "use the key saved in `FILE` by derive-key instead of a password",
+}
+
+// DeriveKey fulfils the `derive-key` subcommand.
+var DeriveKey = cli.Command{
+	Name:  "derive-key",
+	Usage: "Save the backup's key so the password isn't needed again",
+	UsageText: "Derive the keys the backup is encrypted with from its password, and print them or save them to\n" +
+		"FILE for use with --keyfile. Deriving the keys is the slowest part of opening a backup. The keys\n" +
+		"only work for this backup, but they can decrypt it, so keep them as safe as the password.",
+	CustomHelpTemplate: SubcommandHelp,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "save the key to `FILE`, which must not already exist",
+		},
+	}, coreFlags...),
+	Action: func(c *cli.Context) error {
+		bf, err := setup(c)
+		if err != nil {
+			return err
+		}
+		defer bf.Close()
+
+		out := c.String("output")
+		if out == "" {
+			return bf.Key().Save(os.Stdout)
+		}
+		return writeKeyfile(out, bf.Key())
+	},
+}
+
+// writeKeyfile saves key to a new file at path that only the current user can read.
+func writeKeyfile(path string, key *types.BackupKey) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return errors.Errorf("%s already exists", path)
+	} else if err != nil {
+		return errors.Wrap(err, "unable to create key file")
+	}
+
+	if err = key.Save(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(path)
+		return errors.Wrap(err, "unable to write key file")
+	}
+	return nil
+}
+
+// readKeyfile loads a key saved by writeKeyfile. Like SSH private keys, keys that other users can
+// read are refused.
+func readKeyfile(path string) (*types.BackupKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open key file")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open key file")
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, errors.Errorf("%s can be read by other users; restrict it with `chmod 600 %s`", path, path)
+	}
+
+	key, err := types.LoadBackupKey(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read key file %s", path)
+	}
+	return key, nil
+}
@@ -0,0 +1,583 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+// Diff fulfils the `diff` subcommand.
+var Diff = cli.Command{
+	Name:  "diff",
+	Usage: "Compare two backups",
+	UsageText: "Report the rows, messages and attachments that were added to, removed from or changed in NEW\n" +
+		"since OLD, per table and per thread. Valid formats include: TEXT, JSON.",
+	ArgsUsage:          "OLD NEW",
+	CustomHelpTemplate: SubcommandHelp,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Usage: "output the comparison as `FORMAT`",
+			Value: "text",
+		},
+		cli.StringFlag{
+			Name:  "new-password",
+			Usage: "use `PASS` as password for NEW, if it's different to OLD's",
+		},
+		cli.StringFlag{
+			Name:  "new-pwdfile",
+			Usage: "read password for NEW from `FILE`",
+		},
+	}, append(newPasswordFlags("NEW"), coreFlags...)...),
+	Action: func(c *cli.Context) error {
+		if c.Args().Get(1) == "" {
+			return errors.New("must specify two backup files")
+		}
+
+		old, pass, err := setupWithPassword(c)
+		if err != nil {
+			return err
+		}
+		oldSnap, err := SnapshotTables(old)
+		if err != nil {
+			return errors.Wrap(err, "failed to read old backup")
+		}
+
+		// OLD's key won't open NEW, so without a password for OLD, NEW needs its own.
+		src, err := newPasswordSourceFor(c)
+		if err != nil {
+			return err
+		}
+		if src == nil && pass == "" {
+			src = promptPassword("Password for NEW: ")
+		}
+		if src != nil {
+			if backupFromStdin(c) && readsStdin(src) {
+				return errors.New("must give the password for NEW another way when reading a backup from stdin")
+			}
+			if pass, err = readPasswordSource(src); err != nil {
+				return errors.Wrap(err, "unable to read new password")
+			}
+		}
+		bf, err := openBackup(c, c.Args().Get(1), pass)
+		if err != nil {
+			return errors.Wrap(err, "failed to open new backup")
+		}
+		newSnap, err := SnapshotTables(bf)
+		if err != nil {
+			return errors.Wrap(err, "failed to read new backup")
+		}
+
+		d := DiffSnapshots(oldSnap, newSnap)
+
+		switch strings.ToLower(c.String("format")) {
+		case "text":
+			return WriteDiff(d, os.Stdout)
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return errors.Wrap(enc.Encode(d), "failed to write diff")
+		default:
+			return errors.Errorf("format %s not recognised", c.String("format"))
+		}
+	},
+}
+
+// Snapshot holds the identity and a digest of every row in a backup, which is enough to compare it
+// with another without keeping all of either in memory.
+//
+// Row IDs change when a backup is restored, so rows are identified by what they contain instead
+// where possible: recipients by their UUID, phone number, group ID or email address, messages by
+// their thread, when they were sent, to whom and their type, threads by their recipients, and
+// parts by their unique ID. Rows of other tables are identified by their `_id`.
+//
+// Newer versions of Signal refer to recipients by the ID of their row, and write the recipient
+// table after the threads and messages, so those are only identified once the whole backup has
+// been read.
+type Snapshot struct {
+	// Row contents by identity, for each table other than sms, mms and thread.
+	tables map[string]map[string]string
+	// Messages, which are compared once their threads and recipients are known.
+	messages []snapshotMessage
+	// Threads by ID.
+	threads map[uint64]snapshotRow
+	// Recipient identities by ID.
+	recipients map[uint64]string
+	// Attachment lengths by attachment ID.
+	attachments map[uint64]uint32
+}
+
+// snapshotMessage is a row of the sms or mms table.
+type snapshotMessage struct {
+	table  string
+	thread uint64
+	// Who the message was sent to or from, either as the ID of a recipient or an address.
+	address  string
+	identity string
+	contents string
+}
+
+// snapshotRow is a row whose identity depends on the recipients it refers to.
+type snapshotRow struct {
+	recipients string
+	contents   string
+}
+
+// messageGroup is the messages of a backup that have the same identity. Messages sent at the same
+// time can't be told apart, so they're compared as a group.
+type messageGroup struct {
+	table    string
+	thread   string
+	contents []string
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		tables:      map[string]map[string]string{},
+		threads:     map[uint64]snapshotRow{},
+		recipients:  map[uint64]string{},
+		attachments: map[uint64]uint32{},
+	}
+}
+
+// SnapshotTables reads every row and attachment in the backup file.
+func SnapshotTables(bf *types.BackupFile) (*Snapshot, error) {
+	s := newSnapshot()
+
+	discard := types.DiscardConsumeFuncs(bf)
+	err := bf.Consume(types.ConsumeFuncs{
+		StatementFunc: func(stmt *signal.SqlStatement) error {
+			if !isInsert(stmt) || types.IsInternalStatement(stmt) {
+				return nil
+			}
+			r, err := bf.Schema.Row(stmt)
+			if err != nil {
+				return err
+			}
+			return s.add(r)
+		},
+		AttachmentFunc: func(a *signal.Attachment) error {
+			s.attachments[a.GetAttachmentId()] = a.GetLength()
+			return discard.AttachmentFunc(a)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Snapshot) add(r *types.Row) error {
+	switch r.Table {
+	case "sms", "mms":
+		id, err := messageIdentity(r)
+		if err != nil {
+			return err
+		}
+		s.messages = append(s.messages, snapshotMessage{
+			table:    r.Table,
+			thread:   r.Param("thread_id").GetIntegerParameter(),
+			address:  paramString(r.Param("address")),
+			identity: id,
+			contents: rowContents(r),
+		})
+		return nil
+	case "thread":
+		s.threads[r.Param("_id").GetIntegerParameter()] = snapshotRow{
+			recipients: threadRecipient(r),
+			contents:   rowContents(r),
+		}
+		return nil
+	case "recipient":
+		if keys := recipientKeys(r); len(keys) > 0 {
+			s.recipients[r.Param("_id").GetIntegerParameter()] = keys[0]
+		}
+	}
+
+	id, err := rowIdentity(r)
+	if err != nil {
+		return err
+	}
+	if s.tables[r.Table] == nil {
+		s.tables[r.Table] = map[string]string{}
+	}
+	s.tables[r.Table][id] = rowContents(r)
+	return nil
+}
+
+// recipient returns the identity of the recipients referred to by a comma-separated list of
+// recipient IDs. Anything else, such as the addresses older versions of Signal used, is its own
+// identity.
+func (s *Snapshot) recipient(ids string) string {
+	parts := strings.Split(ids, ",")
+	for i, id := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return ids
+		}
+		r, ok := s.recipients[n]
+		if !ok {
+			return ids
+		}
+		parts[i] = r
+	}
+	return strings.Join(parts, ",")
+}
+
+// thread returns the identity of a thread.
+func (s *Snapshot) thread(id uint64) string {
+	if t, ok := s.threads[id]; ok && t.recipients != "" {
+		return s.recipient(t.recipients)
+	}
+	return fmt.Sprintf("thread %d", id)
+}
+
+// threadTable returns the contents of the thread table by identity.
+func (s *Snapshot) threadTable() map[string]string {
+	if len(s.threads) == 0 {
+		return nil
+	}
+	table := map[string]string{}
+	for id, t := range s.threads {
+		table[s.thread(id)] = t.contents
+	}
+	return table
+}
+
+// messageGroups groups the messages of the backup by their thread and identity.
+func (s *Snapshot) messageGroups() map[string]*messageGroup {
+	groups := map[string]*messageGroup{}
+	for _, m := range s.messages {
+		thread := s.thread(m.thread)
+		key := strings.Join([]string{m.table, thread, m.identity, s.recipient(m.address)}, "\x00")
+		g, ok := groups[key]
+		if !ok {
+			g = &messageGroup{table: m.table, thread: thread}
+			groups[key] = g
+		}
+		g.contents = append(g.contents, m.contents)
+	}
+	return groups
+}
+
+// compareMessages counts the messages of a group that are only in the new backup, only in the old
+// one, and in both but changed. Messages with the same contents are matched first, and then any
+// left over in both are paired up as changed.
+func compareMessages(before, after []string) (added, removed, changed int) {
+	left := map[string]int{}
+	for _, c := range before {
+		left[c]++
+	}
+	matched := 0
+	for _, c := range after {
+		if left[c] > 0 {
+			left[c]--
+			matched++
+		}
+	}
+
+	added, removed = len(after)-matched, len(before)-matched
+	changed = added
+	if removed < changed {
+		changed = removed
+	}
+	return added - changed, removed - changed, changed
+}
+
+// messageIdentity returns what identifies a message within its thread across backups, apart from
+// who it was sent to or from, which is only known once the backup's recipients are.
+func messageIdentity(r *types.Row) (string, error) {
+	var date *uint64
+	var kind string
+
+	switch r.Table {
+	case "sms":
+		sms, err := types.RowToSMS(r)
+		if err != nil {
+			return "", err
+		}
+		date, kind = sms.DateSent, paramString(r.Param("type"))
+	case "mms":
+		mms, err := types.RowToMMS(r)
+		if err != nil {
+			return "", err
+		}
+		date, kind = mms.DateSent, paramString(r.Param("msg_box"))
+	}
+
+	if date == nil {
+		return "_id " + types.ParameterLiteral(r.Param("_id")), nil
+	}
+	return formatUint64(date) + " " + kind, nil
+}
+
+// rowIdentity returns what identifies a row across backups.
+func rowIdentity(r *types.Row) (string, error) {
+	switch r.Table {
+	case "recipient":
+		if keys := recipientKeys(r); len(keys) > 0 {
+			return keys[0], nil
+		}
+	case "part":
+		if id := r.Integer("unique_id"); id != nil {
+			return formatUint64(id), nil
+		}
+	}
+
+	if r.Has("_id") {
+		return types.ParameterLiteral(r.Param("_id")), nil
+	}
+	// Without anything better, the row is its own identity, so any change to it is reported as a
+	// removal and an addition.
+	return rowContents(r), nil
+}
+
+// recipientIDColumns are the columns that refer to recipients by ID and are part of a row's
+// identity, so they're left out of its contents.
+var recipientIDColumns = map[string][]string{
+	"sms":    {"address"},
+	"mms":    {"address"},
+	"thread": threadRecipientColumns,
+}
+
+// rowContents returns a digest of a row's values, leaving out the IDs that change when a backup is
+// restored.
+func rowContents(r *types.Row) string {
+	skip := map[string]bool{"_id": true, "thread_id": true, "mid": true}
+	for _, c := range recipientIDColumns[r.Table] {
+		skip[c] = true
+	}
+
+	h := sha256.New()
+	for _, c := range r.Columns() {
+		if skip[c] {
+			continue
+		}
+		fmt.Fprintf(h, "%s=%s\x00", c, types.ParameterLiteral(r.Param(c)))
+	}
+	return string(h.Sum(nil)[:16])
+}
+
+// BackupDiff is the difference between two backups.
+type BackupDiff struct {
+	Tables             []TableDiff  `json:"tables"`
+	Threads            []ThreadDiff `json:"threads"`
+	AddedAttachments   []uint64     `json:"added_attachments"`
+	RemovedAttachments []uint64     `json:"removed_attachments"`
+}
+
+// TableDiff counts the rows of a table that differ between two backups.
+type TableDiff struct {
+	Table   string `json:"table"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Changed int    `json:"changed"`
+}
+
+// ThreadDiff counts the messages in a thread that differ between two backups.
+type ThreadDiff struct {
+	Thread          string `json:"thread"`
+	Status          string `json:"status"`
+	NewMessages     int    `json:"new_messages"`
+	MissingMessages int    `json:"missing_messages"`
+	ChangedMessages int    `json:"changed_messages"`
+}
+
+// Statuses of a thread in a diff.
+const (
+	ThreadAdded   = "added"
+	ThreadRemoved = "removed"
+	ThreadChanged = "changed"
+)
+
+// DiffSnapshots compares a backup with an earlier one. Only tables and threads that differ are
+// included.
+func DiffSnapshots(before, after *Snapshot) *BackupDiff {
+	d := &BackupDiff{
+		Tables:             []TableDiff{},
+		Threads:            []ThreadDiff{},
+		AddedAttachments:   []uint64{},
+		RemovedAttachments: []uint64{},
+	}
+
+	tables := map[string]*TableDiff{}
+	tableDiff := func(table string) *TableDiff {
+		if tables[table] == nil {
+			tables[table] = &TableDiff{Table: table}
+		}
+		return tables[table]
+	}
+	beforeTables, afterTables := before.allTables(), after.allTables()
+	for _, table := range unionKeys(beforeTables, afterTables) {
+		td := tableDiff(table)
+		o, n := beforeTables[table], afterTables[table]
+		for id, contents := range n {
+			if oc, ok := o[id]; !ok {
+				td.Added++
+			} else if oc != contents {
+				td.Changed++
+			}
+		}
+		for id := range o {
+			if _, ok := n[id]; !ok {
+				td.Removed++
+			}
+		}
+	}
+
+	threads := map[string]*ThreadDiff{}
+	threadDiff := func(t string) *ThreadDiff {
+		if threads[t] == nil {
+			threads[t] = &ThreadDiff{Thread: t, Status: ThreadChanged}
+		}
+		return threads[t]
+	}
+	beforeMessages, afterMessages := before.messageGroups(), after.messageGroups()
+	for key, g := range afterMessages {
+		var old []string
+		if og, ok := beforeMessages[key]; ok {
+			old = og.contents
+		}
+		added, removed, changed := compareMessages(old, g.contents)
+		if added+removed+changed == 0 {
+			continue
+		}
+		td, thd := tableDiff(g.table), threadDiff(g.thread)
+		td.Added, td.Removed, td.Changed = td.Added+added, td.Removed+removed, td.Changed+changed
+		thd.NewMessages, thd.MissingMessages, thd.ChangedMessages = thd.NewMessages+added, thd.MissingMessages+removed, thd.ChangedMessages+changed
+	}
+	for key, g := range beforeMessages {
+		if _, ok := afterMessages[key]; !ok {
+			tableDiff(g.table).Removed += len(g.contents)
+			threadDiff(g.thread).MissingMessages += len(g.contents)
+		}
+	}
+
+	for _, td := range tables {
+		if td.Added+td.Removed+td.Changed > 0 {
+			d.Tables = append(d.Tables, *td)
+		}
+	}
+	sort.Slice(d.Tables, func(i, j int) bool { return d.Tables[i].Table < d.Tables[j].Table })
+	beforeThreads, afterThreads := threadSet(before), threadSet(after)
+	for t := range afterThreads {
+		if !beforeThreads[t] {
+			threadDiff(t).Status = ThreadAdded
+		}
+	}
+	for t := range beforeThreads {
+		if !afterThreads[t] {
+			threadDiff(t).Status = ThreadRemoved
+		}
+	}
+	for _, td := range threads {
+		d.Threads = append(d.Threads, *td)
+	}
+	sort.Slice(d.Threads, func(i, j int) bool { return d.Threads[i].Thread < d.Threads[j].Thread })
+
+	for id := range after.attachments {
+		if _, ok := before.attachments[id]; !ok {
+			d.AddedAttachments = append(d.AddedAttachments, id)
+		}
+	}
+	for id := range before.attachments {
+		if _, ok := after.attachments[id]; !ok {
+			d.RemovedAttachments = append(d.RemovedAttachments, id)
+		}
+	}
+	sort.Slice(d.AddedAttachments, func(i, j int) bool { return d.AddedAttachments[i] < d.AddedAttachments[j] })
+	sort.Slice(d.RemovedAttachments, func(i, j int) bool { return d.RemovedAttachments[i] < d.RemovedAttachments[j] })
+
+	return d
+}
+
+// WriteDiff writes a human-readable report of a diff.
+func WriteDiff(d *BackupDiff, out io.Writer) error {
+	w := &errWriter{w: out}
+
+	if len(d.Tables) == 0 && len(d.Threads) == 0 && len(d.AddedAttachments) == 0 && len(d.RemovedAttachments) == 0 {
+		w.printf("No differences.\n")
+		return w.err
+	}
+
+	if len(d.Tables) > 0 {
+		w.printf("Tables:\n")
+		for _, t := range d.Tables {
+			w.printf("  %-30s %6d added %6d removed %6d changed\n", t.Table, t.Added, t.Removed, t.Changed)
+		}
+	}
+	if len(d.Threads) > 0 {
+		w.printf("Threads:\n")
+		for _, t := range d.Threads {
+			w.printf("  %-30s %-8s %6d new messages %6d missing messages %6d changed messages\n",
+				t.Thread, t.Status, t.NewMessages, t.MissingMessages, t.ChangedMessages)
+		}
+	}
+	if len(d.AddedAttachments)+len(d.RemovedAttachments) > 0 {
+		w.printf("Attachments:\n")
+		for _, id := range d.AddedAttachments {
+			w.printf("  + %d\n", id)
+		}
+		for _, id := range d.RemovedAttachments {
+			w.printf("  - %d\n", id)
+		}
+	}
+
+	return errors.Wrap(w.err, "failed to write diff")
+}
+
+// errWriter keeps the first error from a series of writes, so that it only has to be checked once.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) printf(format string, args ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+func threadSet(s *Snapshot) map[string]bool {
+	set := map[string]bool{}
+	for id := range s.threads {
+		set[s.thread(id)] = true
+	}
+	return set
+}
+
+// allTables returns the contents of every table but sms and mms by identity.
+func (s *Snapshot) allTables() map[string]map[string]string {
+	tables := map[string]map[string]string{}
+	for table, rows := range s.tables {
+		tables[table] = rows
+	}
+	if threads := s.threadTable(); threads != nil {
+		tables["thread"] = threads
+	}
+	return tables
+}
+
+func unionKeys(a, b map[string]map[string]string) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+// testSnapshot takes a snapshot of the rows inserted by a series of statements, as SnapshotTables
+// would of a backup made of them.
+func testSnapshot(t *testing.T, stmts ...*signal.SqlStatement) *Snapshot {
+	schema := types.NewSchema()
+	s := newSnapshot()
+	for _, stmt := range stmts {
+		if err := schema.Observe(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt.GetStatement(), err)
+		}
+		if !isInsert(stmt) {
+			continue
+		}
+		r, err := schema.Row(stmt)
+		if err != nil {
+			t.Fatalf("%s: %v", stmt.GetStatement(), err)
+		}
+		if err = s.add(r); err != nil {
+			t.Fatalf("%s: %v", stmt.GetStatement(), err)
+		}
+	}
+	return s
+}
+
+func testStatement(sql string, params ...interface{}) *signal.SqlStatement {
+	stmt := &signal.SqlStatement{Statement: proto.String(sql)}
+	for _, p := range params {
+		switch p := p.(type) {
+		case int:
+			stmt.Parameters = append(stmt.Parameters, &signal.SqlStatement_SqlParameter{IntegerParameter: proto.Uint64(uint64(p))})
+		case string:
+			stmt.Parameters = append(stmt.Parameters, &signal.SqlStatement_SqlParameter{StringParamter: proto.String(p)})
+		case nil:
+			stmt.Parameters = append(stmt.Parameters, &signal.SqlStatement_SqlParameter{Nullparameter: proto.Bool(true)})
+		}
+	}
+	return stmt
+}
+
+// testRecipientBackup is a backup with a thread each with alice and bob, written as Signal writes
+// them: with messages and threads referring to recipients by the ID of their row, and the
+// recipient table last. ids are alice's and bob's recipient IDs.
+func testRecipientBackup(t *testing.T, ids [2]int, extra ...*signal.SqlStatement) *Snapshot {
+	alice, bob := ids[0], ids[1]
+	stmts := []*signal.SqlStatement{
+		testStatement("CREATE TABLE sms (_id INTEGER PRIMARY KEY, thread_id INTEGER, address INTEGER, date INTEGER, date_sent INTEGER, type INTEGER, body TEXT)"),
+		testStatement("CREATE TABLE thread (_id INTEGER PRIMARY KEY, date INTEGER, message_count INTEGER, thread_recipient_id INTEGER)"),
+		testStatement("CREATE TABLE recipient (_id INTEGER PRIMARY KEY, uuid TEXT, phone TEXT)"),
+		testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 1, alice, alice, 1005, 1000, 87, "hi alice"),
+		// Sent at the same time as the first, but to bob.
+		testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 2, bob, bob, 1005, 1000, 87, "hi bob"),
+		testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 3, bob, bob, 2005, 2000, 20, "hello"),
+	}
+	stmts = append(stmts, extra...)
+	stmts = append(stmts,
+		testStatement("INSERT INTO thread VALUES (?,?,?,?)", alice, 1005, 1, alice),
+		testStatement("INSERT INTO thread VALUES (?,?,?,?)", bob, 2005, 2, bob),
+		testStatement("INSERT INTO recipient VALUES (?,?,?)", alice, "uuid-alice", "+61400000001"),
+		testStatement("INSERT INTO recipient VALUES (?,?,?)", bob, nil, "+61400000002"),
+	)
+	return testSnapshot(t, stmts...)
+}
+
+func TestDiffRecipientIDsChanged(t *testing.T) {
+	before := testRecipientBackup(t, [2]int{1, 2})
+	// As after Signal is reinstalled and the backup restored.
+	after := testRecipientBackup(t, [2]int{7, 3})
+
+	d := DiffSnapshots(before, after)
+	if len(d.Tables) != 0 || len(d.Threads) != 0 {
+		t.Errorf("got differences between backups with the same history: %+v", d)
+	}
+}
+
+func TestDiffRecipientIDsChangedWithNewMessage(t *testing.T) {
+	before := testRecipientBackup(t, [2]int{1, 2})
+	after := testRecipientBackup(t, [2]int{7, 3},
+		testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 4, 3, 3, 3005, 3000, 87, "still there?"))
+
+	d := DiffSnapshots(before, after)
+	want := []TableDiff{{Table: "sms", Added: 1}}
+	if len(d.Tables) != 1 || d.Tables[0] != want[0] {
+		t.Errorf("got table differences %+v, want %+v", d.Tables, want)
+	}
+	wantThread := ThreadDiff{Thread: "phone:+61400000002", Status: ThreadChanged, NewMessages: 1}
+	if len(d.Threads) != 1 || d.Threads[0] != wantThread {
+		t.Errorf("got thread differences %+v, want %+v", d.Threads, wantThread)
+	}
+}
@@ -6,10 +6,13 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/h2non/filetype"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
 	"github.com/xeals/signal-back/types"
 )
 
@@ -24,12 +27,53 @@ var Extract = cli.Command{
 			Name:  "outdir, o",
 			Usage: "output attachments to `DIRECTORY`",
 		},
+		cli.Int64SliceFlag{
+			Name:  "id",
+			Usage: "only extract the attachment with `ID`; may be given more than once",
+		},
+		cli.StringFlag{
+			Name:  "index",
+			Usage: "find attachments using the index in `FILE` (default: BACKUPFILE.idx)",
+		},
+		cli.IntFlag{
+			Name:  "jobs, j",
+			Usage: "decrypt up to `N` attachments at once",
+			Value: 1,
+		},
+		sinceStateFlag,
+		progressFlag,
 	}, coreFlags...),
 	Action: func(c *cli.Context) error {
 		bf, err := setup(c)
 		if err != nil {
 			return err
 		}
+		showProgress(c, bf, c.Bool("verbose"))
+
+		jobs := c.Int("jobs")
+		if jobs < 1 {
+			return errors.New("--jobs must be at least 1")
+		}
+		if jobs > 1 && !bf.Seekable() {
+			log.Println("attachments can only be decrypted in parallel from a plain backup file; using one job")
+			jobs = 1
+		}
+
+		ids := c.Int64Slice("id")
+		var idx *types.FrameIndex
+		if len(ids) > 0 {
+			if c.String("since-state") != "" {
+				return errors.New("--id can't be used with --since-state")
+			}
+			if idx, err = loadIndex(bf, c.Args().Get(0), c.String("index")); err != nil {
+				return errors.Wrap(err, "failed to load index")
+			}
+		}
+
+		state, err := startIncrementalExport(bf, c.String("since-state"))
+		if err != nil {
+			return err
+		}
 
 		if path := c.String("outdir"); path != "" {
 			err := os.MkdirAll(path, 0755)
@@ -41,24 +85,57 @@ var Extract = cli.Command{
 				return errors.Wrap(err, "unable to change working directory")
 			}
 		}
-		if err = ExtractAttachments(bf); err != nil {
+		switch {
+		case len(ids) > 0:
+			err = ExtractAttachmentsByID(bf, idx, ids)
+		case jobs > 1:
+			err = ExtractAttachmentsConcurrently(bf, jobs)
+		default:
+			err = ExtractAttachments(bf)
+		}
+		if err != nil {
 			return errors.Wrap(err, "failed to extract attachment")
 		}
 
-		return nil
+		return state.finish()
 	},
 }
 
 // ExtractAttachments pulls only the attachments out of the backup file and
 // outputs them in the current working directory.
 func ExtractAttachments(bf *types.BackupFile) error {
+	defer bf.Close()
+	return extractAttachments(bf, nil)
+}
+
+// ExtractAttachmentsConcurrently is like ExtractAttachments, but decrypts up to jobs attachments at
+// once. The backup must be seekable.
+//
+// The files written are the same as ExtractAttachments would write. If more than one attachment
+// fails, the error returned is for the one that comes first in the backup.
+func ExtractAttachmentsConcurrently(bf *types.BackupFile, jobs int) error {
+	if !bf.Seekable() {
+		return errors.New("backup must be a plain file to decrypt attachments concurrently")
+	}
+	defer bf.Close()
+
+	pool := newAttachmentPool(bf, jobs)
+	err := extractAttachments(bf, pool)
+	if perr := pool.wait(); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// extractAttachments reads through the backup, decrypting attachments as they're found, or
+// handing them to pool if it isn't nil.
+func extractAttachments(bf *types.BackupFile, pool *attachmentPool) error {
 	aEncs := make(map[uint64]string)
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("Panicked during extraction:", r)
 		}
 	}()
-	defer bf.Close()
 
 	for {
 		f, err := bf.Frame()
@@ -68,10 +145,19 @@ func ExtractAttachments(bf *types.BackupFile) error {
 			return errors.Wrap(err, "extraction")
 		}
 
-		ps := f.GetStatement().GetParameters()
-		if len(ps) == 25 { // Contains blob information
-			aEncs[*ps[19].IntegerParameter] = *ps[3].StringParamter
-			log.Printf("found attachment metadata %v: `%v`\n", *ps[19].IntegerParameter, ps)
+		if stmt := f.GetStatement(); strings.HasPrefix(stmt.GetStatement(), "INSERT INTO part ") {
+			row, err := bf.Schema.Row(stmt)
+			if err != nil {
+				return errors.Wrap(err, "extraction")
+			}
+			part, err := types.RowToPart(row)
+			if err != nil {
+				return errors.Wrap(err, "extraction")
+			}
+			if part.ContentType != nil {
+				aEncs[part.UniqueID] = *part.ContentType
+			}
+			log.Printf("found attachment metadata %v: `%v`\n", part.UniqueID, stmt.GetParameters())
 		}
 
 		if a := f.GetAttachment(); a != nil {
@@ -79,38 +165,159 @@ func ExtractAttachments(bf *types.BackupFile) error {
 			id := *a.AttachmentId
 
 			mime, hasMime := aEncs[id]
-			ext := getExt(mime, id)
-
-			fileName := fmt.Sprintf("%v%s", id, ext)
-			file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY, os.ModePerm)
-
+			if pool != nil {
+				err = pool.submit(id, mime, hasMime, a.GetLength())
+			} else {
+				err = saveAttachment(id, mime, hasMime, func(out io.Writer) error {
+					return bf.DecryptAttachment(a.GetLength(), out)
+				})
+			}
 			if err != nil {
-				return errors.Wrap(err, "failed to open output file")
+				return err
 			}
-			if err = bf.DecryptAttachment(a.GetLength(), file); err != nil {
-				return errors.Wrap(err, "failed to decrypt attachment")
+		}
+
+		if a := f.GetAvatar(); a != nil {
+			if err = bf.DecryptAttachment(a.GetLength(), ioutil.Discard); err != nil {
+				return errors.Wrap(err, "failed to skip avatar")
 			}
-			if err = file.Close(); err != nil {
-				return errors.Wrap(err, "failed to close output file")
+		}
+		if s := f.GetSticker(); s != nil {
+			if err = bf.DecryptAttachment(s.GetLength(), ioutil.Discard); err != nil {
+				return errors.Wrap(err, "failed to skip sticker")
 			}
+		}
+	}
+}
+
+// ExtractAttachmentsByID outputs only the attachments with the given IDs in the current working
+// directory. If idx is nil, the backup is read through to find them instead.
+func ExtractAttachmentsByID(bf *types.BackupFile, idx *types.FrameIndex, ids []int64) error {
+	if idx == nil {
+		return extractAttachmentsByScan(bf, ids)
+	}
+	defer bf.Close()
+
+	for _, id := range ids {
+		id := uint64(id)
+		n, ok := idx.Attachment(id)
+		if !ok {
+			return errors.Errorf("backup has no attachment %d", id)
+		}
+		log.Printf("found attachment binary %v\n", id)
+		mime := idx.Frames[n].ContentType
+		err := saveAttachment(id, mime, mime != "", func(out io.Writer) error {
+			return bf.ExtractAttachment(idx, id, out)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			if !hasMime { // Time to look into the file itself and guess.
-				buf, err := ioutil.ReadFile(fileName)
-				if err != nil {
-					return errors.Wrap(err, "failed to read output file for MIME detection")
-				}
-				kind, err := filetype.Match(buf)
-				if err != nil {
-					log.Printf("unable to detect file type: %s\n", err.Error())
-				}
-				if err = os.Rename(fileName, fileName+"."+kind.Extension); err != nil {
-					log.Println("unknown file type")
-					return errors.Wrap(err, "unable to rename output file")
-				}
-				log.Println("found file type:", kind.MIME)
+// extractAttachmentsByScan reads through the whole backup for the attachments with the given IDs,
+// for when it can't be seeked.
+func extractAttachmentsByScan(bf *types.BackupFile, ids []int64) error {
+	wanted := map[uint64]bool{}
+	for _, id := range ids {
+		wanted[uint64(id)] = false
+	}
+
+	bf.Filter = func(f *signal.BackupFrame) bool {
+		if a := f.GetAttachment(); a != nil {
+			found, ok := wanted[a.GetAttachmentId()]
+			if ok && !found {
+				wanted[a.GetAttachmentId()] = true
+				return true
 			}
+			return false
 		}
+		return f.GetAvatar() == nil && f.GetSticker() == nil
+	}
+	if err := ExtractAttachments(bf); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if !wanted[uint64(id)] {
+			return errors.Errorf("backup has no attachment %d", id)
+		}
+	}
+	return nil
+}
+
+// saveAttachment writes an attachment to a file named after its ID, using decrypt to write its
+// contents. The file extension comes from the attachment's MIME type if it's known, and from the
+// contents of the file if not.
+func saveAttachment(id uint64, mime string, hasMime bool, decrypt func(io.Writer) error) error {
+	fileName := attachmentFileName(id, mime)
+	if err := writeAttachment(fileName, decrypt); err != nil {
+		return err
+	}
+	return nameAttachment(fileName, hasMime)
+}
+
+func attachmentFileName(id uint64, mime string) string {
+	return fmt.Sprintf("%v%s", id, getExt(mime, id))
+}
+
+// writeAttachment writes an attachment to fileName, using decrypt to write its contents.
+//
+// The attachment is only verified once all of it has been decrypted, so it's written to a temporary
+// file next to fileName first, which replaces fileName only if decrypt succeeds. Otherwise it's
+// removed, and nothing is left at fileName.
+func writeAttachment(fileName string, decrypt func(io.Writer) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".")
+	if err != nil {
+		return errors.Wrap(err, "failed to open output file")
+	}
+	tmp := file.Name()
+
+	if err = decrypt(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to decrypt attachment")
+	}
+	// Temporary files can only be read by their owner.
+	if err = file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to set output file permissions")
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to close output file")
+	}
+	if err = os.Rename(tmp, fileName); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to rename output file")
+	}
+	return nil
+}
+
+// nameAttachment adds an extension to an attachment's file name based on its contents, if its MIME
+// type isn't known.
+func nameAttachment(fileName string, hasMime bool) error {
+	if hasMime {
+		return nil
+	}
+
+	// Time to look into the file itself and guess.
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to read output file for MIME detection")
+	}
+	kind, err := filetype.Match(buf)
+	if err != nil {
+		log.Printf("unable to detect file type: %s\n", err.Error())
+	}
+	if err = os.Rename(fileName, fileName+"."+kind.Extension); err != nil {
+		log.Println("unknown file type")
+		return errors.Wrap(err, "unable to rename output file")
 	}
+	log.Println("found file type:", kind.MIME)
+	return nil
 }
 
 func getExt(mime string, file uint64) string {
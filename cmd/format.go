@@ -1,17 +1,23 @@
 package cmd
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"regexp"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 	"github.com/xeals/signal-back/signal"
@@ -20,9 +26,11 @@ import (
 
 // Format fulfils the `format` subcommand.
 var Format = cli.Command{
-	Name:               "format",
-	Usage:              "Read and format the backup file",
-	UsageText:          "Parse and transform the backup file into other formats.\nValid formats include: CSV, XML, RAW.",
+	Name:  "format",
+	Usage: "Read and format the backup file",
+	UsageText: "Parse and transform the backup file into other formats.\nValid formats include: CSV, XML, JSON, NDJSON, SQL, SQLITE, RAW.\n" +
+		"SQLITE needs a build with cgo, which the pre-built binaries for other platforms than the one\n" +
+		"a release was built on don't have.",
 	CustomHelpTemplate: SubcommandHelp,
 	Flags: append([]cli.Flag{
 		cli.StringFlag{
@@ -39,12 +47,37 @@ var Format = cli.Command{
 			Name:  "output, o",
 			Usage: "write decrypted format to `FILE`",
 		},
+		sinceStateFlag,
+		progressFlag,
 	}, coreFlags...),
 	Action: func(c *cli.Context) error {
+		if strings.ToLower(c.String("format")) == "sqlite" {
+			if err := requireSQLite(); err != nil {
+				return err
+			}
+		}
+
 		bf, err := setup(c)
 		if err != nil {
 			return err
 		}
+		showProgress(c, bf, c.Bool("verbose"))
+
+		state, err := startIncrementalExport(bf, c.String("since-state"))
+		if err != nil {
+			return err
+		}
+
+		// SQLite writes to a database rather than a stream, so it needs the path itself.
+		if strings.ToLower(c.String("format")) == "sqlite" {
+			if c.String("output") == "" {
+				return errors.New("must specify an output file with --output for SQLite")
+			}
+			if err = SQLite(bf, c.String("output")); err != nil {
+				return errors.Wrap(err, "failed to format output")
+			}
+			return state.finish()
+		}
 
 		var out io.Writer
 		if c.String("output") != "" {
@@ -69,8 +102,11 @@ var Format = cli.Command{
 		case "xml":
 			err = XML(bf, out)
 		case "json":
-			// err = formatJSON(bf, out)
-			return errors.New("JSON is still TODO")
+			err = JSON(bf, out)
+		case "ndjson":
+			err = NDJSON(bf, out)
+		case "sql":
+			err = SQL(bf, out)
 		case "raw":
 			err = Raw(bf, out)
 		default:
@@ -80,23 +116,153 @@ var Format = cli.Command{
 			return errors.Wrap(err, "failed to format output")
 		}
 
-		return nil
+		return state.finish()
 	},
 }
 
-// JSON <undefined>
+// JSON formats the backup's messages into JSON, nested by thread. The layout is versioned by
+// types.JSONSchemaVersion and described in docs/json-schema-v1.json.
 func JSON(bf *types.BackupFile, out io.Writer) error {
-	return nil
+	attachments := map[uint64]*types.JSONAttachment{}
+	messages := []types.JSONMessage{}
+	mmsIndex := map[uint64]int{}
+	parts := map[uint64][]types.JSONPart{}
+
+	fns := types.ConsumeFuncs{
+		// Only keep the attachment metadata; the data itself belongs to `extract`.
+		AttachmentFunc: func(a *signal.Attachment) error {
+			attachments[a.GetAttachmentId()] = &types.JSONAttachment{
+				ID:     a.GetAttachmentId(),
+				Length: a.GetLength(),
+			}
+			return bf.DecryptAttachment(a.GetLength(), ioutil.Discard)
+		},
+		StatementFunc: func(s *signal.SqlStatement) error {
+			if strings.HasPrefix(s.GetStatement(), "INSERT INTO sms ") {
+				row, err := bf.Schema.Row(s)
+				if err != nil {
+					return err
+				}
+				sms, err := types.RowToSMS(row)
+				if err != nil {
+					return errors.Wrap(err, "sms statement couldn't be generated")
+				}
+				messages = append(messages, types.NewJSONSMS(sms))
+			}
+
+			if strings.HasPrefix(s.GetStatement(), "INSERT INTO mms ") {
+				row, err := bf.Schema.Row(s)
+				if err != nil {
+					return err
+				}
+				mms, err := types.RowToMMS(row)
+				if err != nil {
+					return errors.Wrap(err, "mms statement couldn't be generated")
+				}
+				mmsIndex[mms.ID] = len(messages)
+				messages = append(messages, types.NewJSONMMS(mms))
+			}
+
+			if strings.HasPrefix(s.GetStatement(), "INSERT INTO part ") {
+				row, err := bf.Schema.Row(s)
+				if err != nil {
+					return err
+				}
+				part, err := types.RowToPart(row)
+				if err != nil {
+					return errors.Wrap(err, "mms parts couldn't be generated")
+				}
+				if part.MmsID == nil {
+					return errors.Errorf("part %v has no MMS", part.RowID)
+				}
+				parts[*part.MmsID] = append(parts[*part.MmsID], types.NewJSONPart(part))
+			}
+
+			return nil
+		},
+	}
+
+	if err := bf.Consume(fns); err != nil {
+		return err
+	}
+
+	for mmsID, ps := range parts {
+		i, ok := mmsIndex[mmsID]
+		if !ok {
+			log.Printf("found %d part(s) for missing MMS %v\n", len(ps), mmsID)
+			continue
+		}
+		for j := range ps {
+			ps[j].Attachment = attachments[ps[j].UniqueID]
+		}
+		sort.Slice(ps, func(a, b int) bool { return ps[a].Seq < ps[b].Seq })
+		messages[i].Parts = ps
+	}
+
+	backup := types.JSONBackup{
+		SchemaVersion: types.JSONSchemaVersion,
+		Threads:       types.GroupJSONThreads(messages),
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return errors.WithMessage(enc.Encode(backup), "failed to write out JSON")
 }
 
-// CSV dumps the raw backup data into a comma-separated value format.
+// NDJSON streams every frame in the backup as a separate JSON object, one per line. Attachment,
+// avatar and sticker data is skipped, but their headers are kept.
+func NDJSON(bf *types.BackupFile, out io.Writer) error {
+	defer bf.Close()
+
+	enc := json.NewEncoder(out)
+	for {
+		f, err := bf.Frame()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read frame")
+		}
+
+		// Frames left out by --since-state still count, so that the index is the frame's position
+		// in the backup.
+		if err = enc.Encode(types.NewFrameEvent(bf.FramesRead()-1, f)); err != nil {
+			return errors.Wrap(err, "failed to write out NDJSON")
+		}
+
+		if a := f.GetAttachment(); a != nil {
+			if err = bf.DecryptAttachment(a.GetLength(), ioutil.Discard); err != nil {
+				return errors.Wrap(err, "failed to skip attachment")
+			}
+		}
+		if a := f.GetAvatar(); a != nil {
+			if err = bf.DecryptAttachment(a.GetLength(), ioutil.Discard); err != nil {
+				return errors.Wrap(err, "failed to skip avatar")
+			}
+		}
+		if s := f.GetSticker(); s != nil {
+			if err = bf.DecryptAttachment(s.GetLength(), ioutil.Discard); err != nil {
+				return errors.Wrap(err, "failed to skip sticker")
+			}
+		}
+	}
+}
+
+// CSV dumps the rows of one table of the backup, such as sms or mms, into a comma-separated value
+// format. The header and the order of the values are the table's columns, as the backup creates it.
 func CSV(bf *types.BackupFile, message string, out io.Writer) error {
-	ss := make([][]string, 0)
+	var rows []*types.Row
 
 	fns := types.ConsumeFuncs{
 		StatementFunc: func(s *signal.SqlStatement) error {
-			if (*s.Statement)[:15] == "INSERT INTO "+message {
-				ss = append(ss, types.StatementToStringArray(s))
+			if !isInsert(s) {
+				return nil
+			}
+			r, err := bf.Schema.Row(s)
+			if err != nil {
+				return err
+			}
+			if r.Table == message {
+				rows = append(rows, r)
 			}
 			return nil
 		},
@@ -106,20 +272,26 @@ func CSV(bf *types.BackupFile, message string, out io.Writer) error {
 		return err
 	}
 
-	w := csv.NewWriter(out)
-	var headers []string
-	if message == "mms" {
-		headers = types.MMSCSVHeaders
-	} else {
-		headers = types.SMSCSVHeaders
+	columns := bf.Schema.Columns(message)
+	if columns == nil {
+		return errors.Errorf("the backup has no %s table", message)
 	}
 
+	w := csv.NewWriter(out)
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = strings.ToUpper(column)
+	}
 	if err := w.Write(headers); err != nil {
 		return errors.Wrap(err, "unable to write CSV headers")
 	}
 
-	for _, sms := range ss {
-		if err := w.Write(sms); err != nil {
+	for _, r := range rows {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = csvValue(r.Param(column))
+		}
+		if err := w.Write(values); err != nil {
 			return errors.Wrap(err, "unable to format CSV")
 		}
 	}
@@ -129,6 +301,20 @@ func CSV(bf *types.BackupFile, message string, out io.Writer) error {
 	return errors.WithMessage(w.Error(), "unable to end CSV writer or something")
 }
 
+// csvValue formats a parameter for CSV. Null parameters are left empty, and blobs are base64
+// encoded.
+func csvValue(p *signal.SqlStatement_SqlParameter) string {
+	switch {
+	case p == nil:
+		return ""
+	case p.DoubleParameter != nil:
+		return strconv.FormatFloat(*p.DoubleParameter, 'g', -1, 64)
+	case p.BlobParameter != nil:
+		return base64.StdEncoding.EncodeToString(p.BlobParameter)
+	}
+	return paramString(p)
+}
+
 // XML formats the backup into the same XML format as SMS Backup & Restore
 // uses. Layout described at their website
 // http://synctech.com.au/fields-in-xml-backup-files/
@@ -138,8 +324,6 @@ func XML(bf *types.BackupFile, out io.Writer) error {
 		Body string
 	}
 
-	var attachmentBuffer bytes.Buffer
-	attachmentEncoder := base64.NewEncoder(base64.StdEncoding, &attachmentBuffer)
 	attachments := map[uint64]attachmentDetails{}
 	smses := &types.SMSes{}
 	mmses := map[uint64]types.MMS{}
@@ -148,16 +332,18 @@ func XML(bf *types.BackupFile, out io.Writer) error {
 	fns := types.ConsumeFuncs{
 		// Remove attachment, but keep metadata.
 		AttachmentFunc: func(a *signal.Attachment) error {
-			err := bf.DecryptAttachment(a.GetLength(), attachmentEncoder)
-			attachmentEncoder.Close()
+			r, err := bf.AttachmentReader(a.GetLength())
+			if err != nil {
+				return errors.Wrap(err, "unable to process attachment")
+			}
+			data, err := ioutil.ReadAll(r)
 			if err != nil {
 				return errors.Wrap(err, "unable to process attachment")
 			}
 			attachments[*a.AttachmentId] = attachmentDetails{
 				Size: uint64(*a.Length),
-				Body: attachmentBuffer.String(),
+				Body: base64.StdEncoding.EncodeToString(data),
 			}
-			attachmentBuffer.Reset()
 			return nil
 		},
 		StatementFunc: func(s *signal.SqlStatement) error {
@@ -172,24 +358,36 @@ func XML(bf *types.BackupFile, out io.Writer) error {
 			}()
 
 			// Only use SMS/MMS statements
-			if strings.HasPrefix(*s.Statement, "INSERT INTO sms") {
-				sms, err := types.NewSMSFromStatement(s)
+			if strings.HasPrefix(*s.Statement, "INSERT INTO sms ") {
+				row, err := bf.Schema.Row(s)
+				if err != nil {
+					return err
+				}
+				sms, err := types.NewSMSFromRow(row)
 				if err != nil {
 					return errors.Wrap(err, "sms statement couldn't be generated")
 				}
 				smses.SMS = append(smses.SMS, *sms)
 			}
 
-			if strings.HasPrefix(*s.Statement, "INSERT INTO mms") {
-				id, mms, err := types.NewMMSFromStatement(s)
+			if strings.HasPrefix(*s.Statement, "INSERT INTO mms ") {
+				row, err := bf.Schema.Row(s)
+				if err != nil {
+					return err
+				}
+				id, mms, err := types.NewMMSFromRow(row)
 				if err != nil {
 					return errors.Wrap(err, "mms statement couldn't be generated")
 				}
 				mmses[id] = *mms
 			}
 
-			if strings.HasPrefix(*s.Statement, "INSERT INTO part") {
-				mmsId, part, err := types.NewPartFromStatement(s)
+			if strings.HasPrefix(*s.Statement, "INSERT INTO part ") {
+				row, err := bf.Schema.Row(s)
+				if err != nil {
+					return err
+				}
+				mmsId, part, err := types.NewPartFromRow(row)
 				if err != nil {
 					return errors.Wrap(err, "mms parts couldn't be generated")
 				}
@@ -264,6 +462,128 @@ func XML(bf *types.BackupFile, out io.Writer) error {
 	return errors.WithMessage(w.Error(), "failed to write out XML")
 }
 
+// SQLite replays every statement in the backup into a new SQLite database at path, recreating the
+// database the Signal app had at the time of the backup. Attachments are not stored.
+func SQLite(bf *types.BackupFile, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("%s already exists", path)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create database")
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	// Most statements are the same INSERT repeated many times over.
+	prepared := map[string]*sql.Stmt{}
+	// Virtual tables whose module SQLite was built without, and so couldn't be created.
+	var missing []*regexp.Regexp
+
+	fns := types.ConsumeFuncs{
+		StatementFunc: func(s *signal.SqlStatement) error {
+			if types.IsInternalStatement(s) {
+				log.Println("skipping internal statement:", s.GetStatement())
+				return nil
+			}
+			for _, table := range missing {
+				if table.MatchString(s.GetStatement()) {
+					log.Println("skipping statement on missing table:", s.GetStatement())
+					return nil
+				}
+			}
+
+			stmt, ok := prepared[s.GetStatement()]
+			if !ok {
+				// Only INSERTs are repeated, so there is no point preparing anything else.
+				if !strings.HasPrefix(s.GetStatement(), "INSERT INTO") {
+					_, err := tx.Exec(s.GetStatement(), types.StatementArgs(s)...)
+					// The search indices are only an optimisation for the app, which it rebuilds
+					// if they're missing, so they're left out rather than failing.
+					if table, module, ok := virtualTable(s.GetStatement()); ok && err != nil && strings.Contains(err.Error(), "no such module") {
+						log.Printf("skipping %s: SQLite was built without the %s module\n", table, module)
+						missing = append(missing, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(table)+`\b`))
+						return nil
+					}
+					return errors.Wrapf(err, "failed to execute `%s`", s.GetStatement())
+				}
+				stmt, err = tx.Prepare(s.GetStatement())
+				if err != nil {
+					return errors.Wrapf(err, "failed to prepare `%s`", s.GetStatement())
+				}
+				prepared[s.GetStatement()] = stmt
+			}
+
+			_, err := stmt.Exec(types.StatementArgs(s)...)
+			return errors.Wrapf(err, "failed to execute `%s`", s.GetStatement())
+		},
+	}
+
+	if err = bf.Consume(fns); err != nil {
+		return err
+	}
+
+	for _, stmt := range prepared {
+		stmt.Close()
+	}
+
+	return errors.Wrap(tx.Commit(), "unable to commit database")
+}
+
+// virtualTable returns the name and module of the table created by a CREATE VIRTUAL TABLE
+// statement, such as the fts5 search indices.
+func virtualTable(stmt string) (table, module string, ok bool) {
+	fields := strings.Fields(stmt)
+	if len(fields) < 3 || !strings.EqualFold(strings.Join(fields[:3], " "), "create virtual table") {
+		return "", "", false
+	}
+	fields = fields[3:]
+	if len(fields) >= 3 && strings.EqualFold(strings.Join(fields[:3], " "), "if not exists") {
+		fields = fields[3:]
+	}
+	if len(fields) < 3 || !strings.EqualFold(fields[1], "using") {
+		return "", "", false
+	}
+
+	table = strings.Trim(fields[0], "\"`'[]")
+	module = strings.ToLower(strings.SplitN(fields[2], "(", 2)[0])
+	return table, module, true
+}
+
+// SQL writes the backup out as a plain SQL script, with every parameter inlined, that can be loaded
+// into SQLite to recreate the database. Attachments are not included.
+func SQL(bf *types.BackupFile, out io.Writer) error {
+	w := types.NewMultiWriter(out)
+	w.W([]byte("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"))
+
+	fns := types.ConsumeFuncs{
+		StatementFunc: func(s *signal.SqlStatement) error {
+			if types.IsInternalStatement(s) {
+				return nil
+			}
+			stmt, err := types.InlineStatement(s)
+			if err != nil {
+				return errors.Wrapf(err, "unable to inline `%s`", s.GetStatement())
+			}
+			w.W([]byte(stmt + ";\n"))
+			return w.Error()
+		},
+	}
+
+	if err := bf.Consume(fns); err != nil {
+		return err
+	}
+
+	w.W([]byte("COMMIT;\n"))
+	return errors.WithMessage(w.Error(), "failed to write out SQL")
+}
+
 // Raw performs an ever plainer dump than CSV, and is largely unusable for any purpose outside
 // debugging.
 func Raw(bf *types.BackupFile, out io.Writer) error {
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/types"
+)
+
+// indexSuffix is added to the name of a backup to find its index.
+const indexSuffix = ".idx"
+
+// Index fulfils the `index` subcommand.
+var Index = cli.Command{
+	Name:  "index",
+	Usage: "Record where every frame in the backup is",
+	UsageText: "Write an index of the backup to BACKUPFILE.idx, so that attachments can be extracted from it\n" +
+		"without reading the rest of the backup. The index only holds positions and attachment IDs.",
+	CustomHelpTemplate: SubcommandHelp,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write the index to `FILE` instead",
+		},
+	}, coreFlags...),
+	Action: func(c *cli.Context) error {
+		bf, err := setup(c)
+		if err != nil {
+			return err
+		}
+		defer bf.Close()
+
+		out := c.String("output")
+		if out == "" {
+			if c.Args().Get(0) == "-" {
+				return errors.New("must specify an output file when reading from stdin")
+			}
+			out = c.Args().Get(0) + indexSuffix
+		}
+
+		idx, err := bf.Index()
+		if err != nil {
+			return errors.Wrap(err, "failed to index backup")
+		}
+
+		file, err := os.Create(out)
+		if err != nil {
+			return errors.Wrap(err, "unable to create index file")
+		}
+		if err = idx.Save(file); err != nil {
+			file.Close()
+			return err
+		}
+		return errors.Wrap(file.Close(), "unable to write index file")
+	},
+}
+
+// loadIndex finds the index for the backup at path, reading it from indexPath if that's given or
+// from next to the backup if there is one there. Failing that, the backup is indexed now if it can
+// be. If it can't, nil is returned.
+func loadIndex(bf *types.BackupFile, path, indexPath string) (*types.FrameIndex, error) {
+	explicit := indexPath != ""
+	if !explicit {
+		indexPath = path + indexSuffix
+	}
+
+	file, err := os.Open(indexPath)
+	if err == nil {
+		defer file.Close()
+		idx, err := types.LoadFrameIndex(file)
+		switch {
+		case err != nil && explicit:
+			return nil, err
+		case err != nil:
+			log.Printf("ignoring %s: %s\n", indexPath, err)
+		case !idx.Matches(bf) && explicit:
+			return nil, errors.Errorf("%s is the index of a different backup", indexPath)
+		case !idx.Matches(bf):
+			log.Printf("ignoring %s: it is the index of a different backup\n", indexPath)
+		default:
+			return idx, nil
+		}
+	} else if explicit || !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "unable to read index file")
+	}
+
+	if !bf.Seekable() {
+		return nil, nil
+	}
+	log.Println("indexing backup; run `signal-back index` first to skip this next time")
+	return bf.Index()
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Magic numbers used to recognise archives.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is where the magic number appears in a tar header.
+const tarMagicOffset = 257
+
+// input is an opened backup, along with everything that needs closing once it has been read.
+type input struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (in *input) Close() error {
+	var err error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if cerr := in.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openInput opens the backup at name, or reads it from stdin if name is "-". If the backup is inside
+// a zip, tar or gzip archive, it is streamed out of the archive without unpacking it to disk.
+//
+// member picks which file in an archive is the backup. If it's empty, the first file named like
+// `signal-*.backup` is used.
+//
+// The returned size is zero if the length of the backup isn't known up front.
+func openInput(name, member string) (io.ReadCloser, int64, error) {
+	var (
+		file *os.File
+		size int64
+	)
+
+	if name == "-" {
+		file = os.Stdin
+	} else {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "unable to open backup file")
+		}
+		if file, err = os.Open(name); err != nil {
+			return nil, 0, errors.Wrap(err, "unable to open backup file")
+		}
+		size = info.Size()
+	}
+
+	// Plain backup files are read directly, so that they can be seeked with an index.
+	if size > 0 && !isArchive(file) {
+		if member != "" {
+			file.Close()
+			return nil, 0, errors.New("--member can only be used with zip and tar archives")
+		}
+		return file, size, nil
+	}
+
+	in := &input{closers: []io.Closer{file}}
+	r, size, err := unwrapArchive(in, file, size, member)
+	if err != nil {
+		in.Close()
+		return nil, 0, err
+	}
+	in.Reader = r
+	return in, size, nil
+}
+
+// unwrapArchive returns the reader for the backup inside file, adding anything that needs closing
+// to in.
+func unwrapArchive(in *input, file *os.File, size int64, member string) (io.Reader, int64, error) {
+	br := bufio.NewReader(file)
+	magic, _ := br.Peek(tarMagicOffset + len(tarMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		if size == 0 {
+			return nil, 0, errors.New("zip archives can't be read from stdin")
+		}
+		return openZipMember(in, file, size, member)
+
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "unable to read gzip archive")
+		}
+		in.closers = append(in.closers, gz)
+
+		// A gzipped file is either a tarball or just the backup itself.
+		br = bufio.NewReader(gz)
+		magic, _ = br.Peek(tarMagicOffset + len(tarMagic))
+		if isTar(magic) {
+			return openTarMember(br, member)
+		}
+		if member != "" {
+			return nil, 0, errors.New("--member can only be used with zip and tar archives")
+		}
+		return br, 0, nil
+
+	case isTar(magic):
+		return openTarMember(br, member)
+	}
+
+	if member != "" {
+		return nil, 0, errors.New("--member can only be used with zip and tar archives")
+	}
+	return br, size, nil
+}
+
+// isArchive reports whether a file starts with the magic number of any archive, without moving
+// through it.
+func isArchive(file *os.File) bool {
+	magic := make([]byte, tarMagicOffset+len(tarMagic))
+	n, _ := file.ReadAt(magic, 0)
+	magic = magic[:n]
+	return bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, gzipMagic) || isTar(magic)
+}
+
+func isTar(magic []byte) bool {
+	return len(magic) >= tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(magic[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic)
+}
+
+func openZipMember(in *input, file *os.File, size int64, member string) (io.Reader, int64, error) {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to read zip archive")
+	}
+
+	for _, f := range zr.File {
+		if !isBackupMember(f.Name, member) {
+			continue
+		}
+		log.Printf("reading %s from zip archive\n", f.Name)
+		rc, err := f.Open()
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "unable to read %s from zip archive", f.Name)
+		}
+		in.closers = append(in.closers, rc)
+		return rc, int64(f.UncompressedSize64), nil
+	}
+
+	return nil, 0, noMemberError(member)
+}
+
+func openTarMember(r io.Reader, member string) (io.Reader, int64, error) {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, 0, noMemberError(member)
+		} else if err != nil {
+			return nil, 0, errors.Wrap(err, "unable to read tar archive")
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !isBackupMember(hdr.Name, member) {
+			continue
+		}
+		log.Printf("reading %s from tar archive\n", hdr.Name)
+		return tr, hdr.Size, nil
+	}
+}
+
+// isBackupMember reports whether an archived file is the backup. If member is given, it can match
+// either the full path or just the file name.
+func isBackupMember(name, member string) bool {
+	if member != "" {
+		return name == member || path.Base(name) == member
+	}
+	base := path.Base(name)
+	return strings.HasPrefix(base, "signal-") && strings.HasSuffix(base, ".backup")
+}
+
+func noMemberError(member string) error {
+	if member != "" {
+		return errors.Errorf("archive has no file named %s", member)
+	}
+	return errors.New("archive has no signal-*.backup file; use --member to pick one")
+}
@@ -0,0 +1,759 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+// Merge fulfils the `merge` subcommand.
+var Merge = cli.Command{
+	Name:  "merge",
+	Usage: "Combine the message history of several backups",
+	UsageText: "Write a backup to OUTFILE with everything from the first backup, plus the recipients, threads,\n" +
+		"messages and attachments from the others that it doesn't already have. Messages are the same if\n" +
+		"they were sent at the same time, to the same address, with the same body. The reactions, mentions,\n" +
+		"group receipts and drafts of new messages and threads are merged with them, and the thread list is\n" +
+		"brought up to date. Other tables are only taken from the first backup.",
+	ArgsUsage:          "BACKUPFILE BACKUPFILE... OUTFILE",
+	CustomHelpTemplate: SubcommandHelp,
+	Flags: append([]cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "password, p",
+			Usage: "use `PASS` as password for the backup files; give once for all of them, or once for each",
+		},
+		cli.StringSliceFlag{
+			Name:  "pwdfile, P",
+			Usage: "read password from `FILE`; give once for all of the backup files, or once for each",
+		},
+		cli.StringSliceFlag{
+			Name:  "password-command",
+			Usage: "read password from the output of `COMMAND`; give once for all of the backup files, or once for each",
+		},
+		cli.IntSliceFlag{
+			Name:  "password-fd",
+			Usage: "read password from the first line of file descriptor `N`; give once for all of the backup files, or once for each",
+		},
+		cli.StringSliceFlag{
+			Name:  "keyfile",
+			Usage: "use the key saved in `FILE` by derive-key instead of a password; give once for each backup file",
+		},
+		cli.StringFlag{
+			Name:  "new-password",
+			Usage: "use `PASS` as password for the new backup file, instead of the first backup's",
+		},
+		cli.StringFlag{
+			Name:  "new-pwdfile",
+			Usage: "read password for the new backup file from `FILE`",
+		},
+	}, append(newPasswordFlags("the new backup file"),
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "enable verbose logging output",
+		},
+	)...),
+	Action: func(c *cli.Context) error {
+		setupLogging(c)
+
+		args := c.Args()
+		if len(args) < 3 {
+			return errors.New("must specify at least two backup files and an output file")
+		}
+		paths, out := args[:len(args)-1], args[len(args)-1]
+		for _, path := range paths {
+			// Every backup but the first is read twice, and the passwords may be asked for.
+			if path == "-" {
+				return errors.New("merge can't read backups from stdin")
+			}
+		}
+		if _, err := os.Stat(out); err == nil {
+			return errors.Errorf("%s already exists", out)
+		}
+
+		passes, keys, err := mergeCredentials(c, paths)
+		if err != nil {
+			return err
+		}
+
+		// Without the first backup's password, the new backup needs one of its own.
+		newPass := passes[0]
+		if newPass == "" || newPasswordGiven(c) {
+			if newPass, err = readNewPassword(c); err != nil {
+				return errors.Wrap(err, "unable to read new password")
+			}
+		}
+
+		if err = mergeBackups(c, paths, passes, keys, out, newPass); err != nil {
+			return errors.Wrap(err, "failed to merge backups")
+		}
+		return nil
+	},
+}
+
+// mergeCredentials returns either the password or the key for each backup. Each of the password
+// flags can be given once for all of the backups, or once for each, but only one of them can be
+// used.
+func mergeCredentials(c *cli.Context, paths []string) ([]string, []*types.BackupKey, error) {
+	sources, err := mergePasswordSources(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyfiles := c.StringSlice("keyfile")
+
+	passes := make([]string, len(paths))
+	keys := make([]*types.BackupKey, len(paths))
+	if len(keyfiles) > 0 {
+		if sources != nil {
+			return nil, nil, errors.New("can't use --keyfile with a password")
+		}
+		if len(keyfiles) != len(paths) {
+			return nil, nil, errors.Errorf("got keys for %d backups, but there are %d", len(keyfiles), len(paths))
+		}
+		for i, file := range keyfiles {
+			if keys[i], err = readKeyfile(file); err != nil {
+				return nil, nil, err
+			}
+		}
+		return passes, keys, nil
+	}
+
+	switch len(sources) {
+	case 0:
+		// Without any passwords given, every backup uses SIGNAL_BACK_PASSWORD if it's set.
+		for i, path := range paths {
+			if passes[i], err = readPasswordSource(defaultPasswordSource(fmt.Sprintf("Password for %s: ", path))); err != nil {
+				return nil, nil, errors.Wrap(err, "unable to read password")
+			}
+		}
+	case 1:
+		// Read only once, as a command may ask for a passphrase of its own, and a file descriptor
+		// can't be read again.
+		pass, err := readPasswordSource(sources[0])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read password")
+		}
+		for i := range passes {
+			passes[i] = pass
+		}
+	case len(paths):
+		for i, src := range sources {
+			if passes[i], err = readPasswordSource(src); err != nil {
+				return nil, nil, errors.Wrapf(err, "unable to read password for %s", paths[i])
+			}
+		}
+	default:
+		return nil, nil, errors.Errorf("got passwords for %d backups, but there are %d", len(sources), len(paths))
+	}
+	return passes, keys, nil
+}
+
+// mergePasswordSources returns the sources given by whichever password flag is used, in the order
+// they were given.
+func mergePasswordSources(c *cli.Context) ([]passwordSource, error) {
+	var passes, files, commands, fds []passwordSource
+	for _, pass := range c.StringSlice("password") {
+		passes = append(passes, literalPassword(pass))
+	}
+	for _, file := range c.StringSlice("pwdfile") {
+		files = append(files, filePassword(file))
+	}
+	for _, command := range c.StringSlice("password-command") {
+		// Backups can't be read from stdin, so the command can have it.
+		commands = append(commands, commandPassword{command: command})
+	}
+	for _, fd := range c.IntSlice("password-fd") {
+		if fd < 0 {
+			return nil, errors.New("--password-fd must be a file descriptor")
+		}
+		fds = append(fds, fdPassword(fd))
+	}
+
+	var sources []passwordSource
+	for _, given := range [][]passwordSource{passes, files, commands, fds} {
+		if len(given) == 0 {
+			continue
+		}
+		if sources != nil {
+			return nil, errors.New("only one of --password, --pwdfile, --password-command and --password-fd can be used")
+		}
+		sources = given
+	}
+	return sources, nil
+}
+
+// openMergeInput opens a backup to merge with its key, if there is one, or its password.
+func openMergeInput(c *cli.Context, path, pass string, key *types.BackupKey) (*types.BackupFile, error) {
+	if key == nil {
+		return openBackup(c, path, pass)
+	}
+	return openBackupWithKey(c, path, key)
+}
+
+// merger tracks what has been written to a merged backup so far.
+type merger struct {
+	bw     *types.BackupWriter
+	frames int
+
+	// Messages already written, by messageKey.
+	messages map[string]bool
+	// The largest row ID written to each table, so that new rows don't collide.
+	maxID map[string]uint64
+	// Thread IDs by recipient.
+	threads map[string]uint64
+	// Recipient IDs by each of their recipientKeys.
+	recipients map[string]uint64
+
+	// IDs of the backup currently being merged in, mapped to their new IDs.
+	recipientIDs map[uint64]uint64
+	newRecipient map[uint64]bool
+	threadIDs    map[uint64]uint64
+	newThread    map[uint64]bool
+	smsIDs       map[uint64]uint64
+	mmsIDs       map[uint64]uint64
+	partIDs      map[uint64]uint64
+	// The number of rows left out of each table that isn't merged, and of messages left out because
+	// their thread isn't in the backup.
+	skipped  map[string]int
+	orphaned map[string]int
+
+	// Thread rows are written last, once every message has been, so that their message counts and
+	// snippets can be brought up to date.
+	threadRows []heldRow
+	summaries  threadSummaries
+}
+
+// heldRow is a row that has been held back, with the statement that inserts it.
+type heldRow struct {
+	stmt *signal.SqlStatement
+	row  *types.Row
+}
+
+// recipientColumns are the columns that identify a recipient across backups. Row IDs differ between
+// devices.
+var recipientColumns = []string{"uuid", "phone", "group_id", "email"}
+
+// referenceRecipientColumns are the columns of the tables that refer to messages that hold a
+// recipient ID.
+var referenceRecipientColumns = map[string][]string{
+	"group_receipts": {"address"},
+	"mention":        {"recipient_id"},
+	"reaction":       {"author_id"},
+	"story_sends":    {"recipient_id"},
+}
+
+// threadRecipientColumns are the columns of the thread table that hold its recipients, in older and
+// newer versions of the app.
+var threadRecipientColumns = []string{"recipient_ids", "thread_recipient_id"}
+
+func mergeBackups(c *cli.Context, paths, passes []string, keys []*types.BackupKey, out, newPass string) error {
+	base, err := openMergeInput(c, paths[0], passes[0], keys[0])
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", paths[0])
+	}
+	defer base.Close()
+
+	return writeBackup(out, newPass, base.Version, func(bw *types.BackupWriter) (int, error) {
+		m := &merger{
+			bw:         bw,
+			messages:   map[string]bool{},
+			maxID:      map[string]uint64{},
+			threads:    map[string]uint64{},
+			recipients: map[string]uint64{},
+			summaries:  threadSummaries{},
+		}
+
+		log.Printf("copying %s\n", paths[0])
+		if err := m.copyBase(base); err != nil {
+			return m.frames, errors.Wrapf(err, "failed to copy %s", paths[0])
+		}
+
+		for i := 1; i < len(paths); i++ {
+			log.Printf("merging %s\n", paths[i])
+			if err := m.merge(c, paths[i], passes[i], keys[i]); err != nil {
+				return m.frames, errors.Wrapf(err, "failed to merge %s", paths[i])
+			}
+		}
+
+		if err := m.writeThreads(); err != nil {
+			return m.frames, err
+		}
+		return m.frames, m.write(&signal.BackupFrame{End: proto.Bool(true)})
+	})
+}
+
+func (m *merger) write(f *signal.BackupFrame) error {
+	m.frames++
+	return m.bw.WriteFrame(f)
+}
+
+// copyBase copies everything but the end frame and the threads from the first backup, and records
+// its messages and threads.
+func (m *merger) copyBase(bf *types.BackupFile) error {
+	for {
+		f, err := bf.Frame()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if f.GetEnd() {
+			continue
+		}
+
+		if stmt := f.GetStatement(); stmt != nil && isInsert(stmt) {
+			r, err := bf.Schema.Row(stmt)
+			if err != nil {
+				return err
+			}
+			if err = m.observe(r); err != nil {
+				return err
+			}
+			if r.Table == "thread" {
+				m.threadRows = append(m.threadRows, heldRow{stmt, r})
+				continue
+			}
+		}
+
+		if err = m.write(f); err != nil {
+			return err
+		}
+		if length := types.FrameDataLength(f); length > 0 {
+			if err = copyAttachment(bf, m.bw, length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// observe records a row that has been written to the merged backup.
+func (m *merger) observe(r *types.Row) error {
+	if id := r.Param("_id").GetIntegerParameter(); id > m.maxID[r.Table] {
+		m.maxID[r.Table] = id
+	}
+
+	switch r.Table {
+	case "sms", "mms":
+		key, err := messageKey(r)
+		if err != nil {
+			return err
+		}
+		m.messages[key] = true
+		return m.summarise(r)
+	case "thread":
+		if recipient := threadRecipient(r); recipient != "" {
+			m.threads[recipient] = r.Param("_id").GetIntegerParameter()
+		}
+	case "recipient":
+		for _, key := range recipientKeys(r) {
+			m.recipients[key] = r.Param("_id").GetIntegerParameter()
+		}
+	}
+	return nil
+}
+
+// summarise adds a message to the summary of its thread.
+func (m *merger) summarise(r *types.Row) error {
+	if r.Table == "sms" {
+		sms, err := types.RowToSMS(r)
+		if err != nil {
+			return err
+		}
+		m.summaries.get(sms.ThreadID).keep(sms.DateReceived, sms.Body, sms.Type, sms.Read)
+		return nil
+	}
+	mms, err := types.RowToMMS(r)
+	if err != nil {
+		return err
+	}
+	m.summaries.get(mms.ThreadID).keep(mms.DateReceived, mms.Body, mms.MessageBox, mms.Read)
+	return nil
+}
+
+// writeThreads writes the threads that have been held back, with their message counts brought up to
+// date. A thread's snippet is replaced if a message merged into it is newer than the one it describes.
+func (m *merger) writeThreads() error {
+	sort.Slice(m.threadRows, func(i, j int) bool {
+		return m.threadRows[i].row.Param("_id").GetIntegerParameter() < m.threadRows[j].row.Param("_id").GetIntegerParameter()
+	})
+	for _, t := range m.threadRows {
+		if s, ok := m.summaries[t.row.Param("_id").GetIntegerParameter()]; ok {
+			if date := t.row.Integer("date"); date == nil || s.date > *date {
+				s.stale = true
+			}
+			s.update(t.row)
+		}
+		if err := m.write(&signal.BackupFrame{Statement: t.stmt}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge adds the recipients and messages from another backup that haven't been written yet.
+//
+// Threads are written after messages in a backup, so the backup is read twice: once to match its
+// recipients and threads with ones that have already been written, and once to copy its messages.
+// Rows of other tables are left out, and reported, unless they belong to a message or thread that
+// has been copied.
+func (m *merger) merge(c *cli.Context, path, pass string, key *types.BackupKey) error {
+	m.recipientIDs = map[uint64]uint64{}
+	m.newRecipient = map[uint64]bool{}
+	m.threadIDs = map[uint64]uint64{}
+	m.newThread = map[uint64]bool{}
+	m.smsIDs = map[uint64]uint64{}
+	m.mmsIDs = map[uint64]uint64{}
+	m.partIDs = map[uint64]uint64{}
+	m.skipped = map[string]int{}
+	m.orphaned = map[string]int{}
+
+	bf, err := openMergeInput(c, path, pass, key)
+	if err != nil {
+		return err
+	}
+	// Reuse the key the second time, rather than deriving it from the password again.
+	key = bf.Key()
+	// Threads can only be matched once every recipient has been.
+	var threads []*types.Row
+	err = bf.Consume(types.ConsumeFuncs{
+		StatementFunc: func(stmt *signal.SqlStatement) error {
+			if !isInsert(stmt) {
+				return nil
+			}
+			r, err := bf.Schema.Row(stmt)
+			if err != nil {
+				return err
+			}
+			switch r.Table {
+			case "recipient":
+				m.mapRecipient(r)
+			case "thread":
+				threads = append(threads, r)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range threads {
+		m.mapThread(r)
+	}
+
+	if bf, err = openBackupWithKey(c, path, key); err != nil {
+		return err
+	}
+	discard := types.DiscardConsumeFuncs(bf)
+	err = bf.Consume(types.ConsumeFuncs{
+		StatementFunc: func(stmt *signal.SqlStatement) error {
+			if !isInsert(stmt) {
+				return nil
+			}
+			r, err := bf.Schema.Row(stmt)
+			if err != nil {
+				return err
+			}
+			keep, err := m.remap(r)
+			if err != nil || !keep {
+				return err
+			}
+			if err = m.observe(r); err != nil {
+				return err
+			}
+			if r.Table == "thread" {
+				m.threadRows = append(m.threadRows, heldRow{stmt, r})
+				return nil
+			}
+			return m.write(&signal.BackupFrame{Statement: stmt})
+		},
+		AttachmentFunc: func(a *signal.Attachment) error {
+			id, ok := m.partIDs[a.GetRowId()]
+			if !ok {
+				return discard.AttachmentFunc(a)
+			}
+			a.RowId = proto.Uint64(id)
+			if err := m.write(&signal.BackupFrame{Attachment: a}); err != nil {
+				return err
+			}
+			return copyAttachment(bf, m.bw, a.GetLength())
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	reportSkipped(path, "messages in threads that aren't in the backup", m.orphaned)
+	// Such as contacts' settings and sticker packs, which the first backup has its own of.
+	reportSkipped(path, "not merged", m.skipped)
+	return nil
+}
+
+// reportSkipped lists the number of rows of each table left out of a backup being merged.
+func reportSkipped(path, why string, skipped map[string]int) {
+	if len(skipped) == 0 {
+		return
+	}
+	tables := make([]string, 0, len(skipped))
+	for table, n := range skipped {
+		tables = append(tables, fmt.Sprintf("%s (%d rows)", table, n))
+	}
+	sort.Strings(tables)
+	fmt.Fprintf(os.Stderr, "%s: %s: %s\n", path, why, strings.Join(tables, ", "))
+}
+
+// mapRecipient picks the ID a recipient from the backup being merged will have. Recipients that
+// share any of their recipientKeys with one that has already been written are merged into it.
+func (m *merger) mapRecipient(r *types.Row) {
+	id := r.Param("_id").GetIntegerParameter()
+	for _, key := range recipientKeys(r) {
+		if existing, ok := m.recipients[key]; ok {
+			m.recipientIDs[id] = existing
+			return
+		}
+	}
+	m.maxID["recipient"]++
+	m.recipientIDs[id] = m.maxID["recipient"]
+	m.newRecipient[id] = true
+}
+
+// mapThread picks the ID a thread from the backup being merged will have. Threads with the same
+// recipients as one that has already been written are merged into it.
+func (m *merger) mapThread(r *types.Row) {
+	id := r.Param("_id").GetIntegerParameter()
+	m.remapThreadRecipients(r)
+	if recipient := threadRecipient(r); recipient != "" {
+		if existing, ok := m.threads[recipient]; ok {
+			m.threadIDs[id] = existing
+			return
+		}
+	}
+	m.maxID["thread"]++
+	m.threadIDs[id] = m.maxID["thread"]
+	m.newThread[id] = true
+}
+
+// remap gives a row from the backup being merged its new IDs, and reports whether it should be
+// written. Duplicate messages, and the parts and other rows that belong to duplicate messages, are
+// left out, as the copy already written has its own. So are messages in threads that aren't in the
+// backup, which the app wouldn't show.
+func (m *merger) remap(r *types.Row) (bool, error) {
+	id := r.Param("_id").GetIntegerParameter()
+
+	switch r.Table {
+	case "recipient":
+		if !m.newRecipient[id] {
+			return false, nil
+		}
+		r.SetInteger("_id", m.recipientIDs[id])
+
+	case "thread":
+		if !m.newThread[id] {
+			return false, nil
+		}
+		r.SetInteger("_id", m.threadIDs[id])
+		m.remapThreadRecipients(r)
+
+	case "sms", "mms":
+		m.remapRecipients(r, "address")
+		key, err := messageKey(r)
+		if err != nil || m.messages[key] {
+			return false, err
+		}
+		if thread := r.Integer("thread_id"); thread != nil {
+			newThread, ok := m.threadIDs[*thread]
+			if !ok {
+				m.orphaned[r.Table]++
+				return false, nil
+			}
+			r.SetInteger("thread_id", newThread)
+		}
+		newID := m.maxID[r.Table] + 1
+		if r.Table == "mms" {
+			m.mmsIDs[id] = newID
+		} else {
+			m.smsIDs[id] = newID
+		}
+		r.SetInteger("_id", newID)
+
+	case "part":
+		mid := r.Integer("mid")
+		if mid == nil {
+			return false, nil
+		}
+		newMid, ok := m.mmsIDs[*mid]
+		if !ok {
+			return false, nil
+		}
+		newID := m.maxID["part"] + 1
+		m.partIDs[id] = newID
+		r.SetInteger("_id", newID)
+		r.SetInteger("mid", newMid)
+
+	case "drafts":
+		// Drafts of threads that have already been written are the first backup's.
+		thread := r.Integer("thread_id")
+		if thread == nil || !m.newThread[*thread] {
+			return false, nil
+		}
+		r.SetInteger("_id", m.maxID[r.Table]+1)
+		r.SetInteger("thread_id", m.threadIDs[*thread])
+
+	default:
+		ref, ok := messageReferences[r.Table]
+		if !ok || !r.Has(ref.column) {
+			m.skipped[r.Table]++
+			return false, nil
+		}
+		return m.remapReference(r, ref), nil
+	}
+
+	return true, nil
+}
+
+// remapReference gives a row that refers to a message its new IDs, and reports whether it should be
+// written.
+func (m *merger) remapReference(r *types.Row, ref messageReference) bool {
+	id, mms := ref.message(r)
+	ids := m.smsIDs
+	if mms {
+		ids = m.mmsIDs
+	}
+	newMessage, ok := ids[id]
+	if !ok {
+		return false
+	}
+	r.SetInteger(ref.column, newMessage)
+
+	if thread := r.Integer("thread_id"); thread != nil {
+		if newThread, ok := m.threadIDs[*thread]; ok {
+			r.SetInteger("thread_id", newThread)
+		}
+	}
+	for _, column := range referenceRecipientColumns[r.Table] {
+		m.remapRecipients(r, column)
+	}
+	if r.Has("_id") {
+		r.SetInteger("_id", m.maxID[r.Table]+1)
+	}
+	return true
+}
+
+func (m *merger) remapThreadRecipients(r *types.Row) {
+	for _, column := range threadRecipientColumns {
+		m.remapRecipients(r, column)
+	}
+}
+
+// remapRecipients gives a column that refers to recipients by ID their new IDs. The column can hold
+// one ID, or a comma-separated list of them; older versions of the app kept addresses instead, which
+// are left as they are.
+func (m *merger) remapRecipients(r *types.Row, column string) {
+	if id := r.Integer(column); id != nil {
+		if newID, ok := m.recipientIDs[*id]; ok {
+			r.SetInteger(column, newID)
+		}
+		return
+	}
+
+	s := r.String(column)
+	if s == nil {
+		return
+	}
+	ids := strings.Split(*s, ",")
+	for i, id := range ids {
+		n, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return
+		}
+		newID, ok := m.recipientIDs[n]
+		if !ok {
+			return
+		}
+		ids[i] = strconv.FormatUint(newID, 10)
+	}
+	joined := strings.Join(ids, ",")
+	r.SetString(column, &joined)
+}
+
+// recipientKeys are what identify a recipient across backups. A recipient can be known by its
+// phone number on one device, and by its UUID as well on another.
+func recipientKeys(r *types.Row) []string {
+	var keys []string
+	for _, column := range recipientColumns {
+		if v := r.String(column); v != nil && *v != "" {
+			keys = append(keys, column+":"+*v)
+		}
+	}
+	return keys
+}
+
+// threadRecipient returns the recipients of a thread, however the app recorded them.
+func threadRecipient(r *types.Row) string {
+	for _, column := range threadRecipientColumns {
+		if s := paramString(r.Param(column)); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// messageKey identifies a message across backups by when it was sent, who it was sent to or from,
+// and what it said.
+func messageKey(r *types.Row) (string, error) {
+	var date, address, body string
+
+	switch r.Table {
+	case "sms":
+		sms, err := types.RowToSMS(r)
+		if err != nil {
+			return "", err
+		}
+		date, body = formatUint64(sms.DateSent), derefString(sms.Body)
+	case "mms":
+		mms, err := types.RowToMMS(r)
+		if err != nil {
+			return "", err
+		}
+		date, body = formatUint64(mms.DateSent), derefString(mms.Body)
+	}
+	// A recipient ID in newer versions of the app, rather than a phone number.
+	address = paramString(r.Param("address"))
+
+	return strings.Join([]string{r.Table, date, address, body}, "\x00"), nil
+}
+
+func formatUint64(n *uint64) string {
+	if n == nil {
+		return ""
+	}
+	return fmt.Sprint(*n)
+}
+
+// paramString formats a text or integer parameter, or returns an empty string for anything else.
+func paramString(p *signal.SqlStatement_SqlParameter) string {
+	switch {
+	case p == nil:
+		return ""
+	case p.StringParamter != nil:
+		return *p.StringParamter
+	case p.IntegerParameter != nil:
+		return strconv.FormatUint(*p.IntegerParameter, 10)
+	}
+	return ""
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
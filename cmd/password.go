@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/types"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// passwordEnv is the environment variable the password is read from if no other source is given.
+const passwordEnv = "SIGNAL_BACK_PASSWORD"
+
+// newPasswordEnv is the environment variable the password for a new backup, or for the second
+// backup of `diff`, is read from if no other source is given.
+const newPasswordEnv = "SIGNAL_BACK_NEW_PASSWORD"
+
+// maxPasswordAttempts is how many times the user is prompted for a password before giving up.
+const maxPasswordAttempts = 3
+
+var passwordFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "password, p",
+		Usage: "use `PASS` as password for backup file",
+	},
+	cli.StringFlag{
+		Name:  "pwdfile, P",
+		Usage: "read password from `FILE`",
+	},
+	cli.StringFlag{
+		Name:  "password-command",
+		Usage: "read password from the output of `COMMAND`",
+	},
+	cli.IntFlag{
+		Name:  "password-fd",
+		Usage: "read password from the first line of file descriptor `N`",
+	},
+}
+
+// newPasswordFlags are the ways of giving a second password other than --new-password and
+// --new-pwdfile, which each command describes itself. what is what the password is for.
+func newPasswordFlags(what string) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "new-password-command",
+			Usage: "read password for " + what + " from the output of `COMMAND`",
+		},
+		cli.IntFlag{
+			Name:  "new-password-fd",
+			Usage: "read password for " + what + " from the first line of file descriptor `N`",
+		},
+	}
+}
+
+// passwordSource is somewhere a backup's password can be read from.
+type passwordSource interface {
+	read() (string, error)
+	// prompts reports whether reading the password asks the user for it on the terminal.
+	prompts() bool
+}
+
+type (
+	// literalPassword is a password given on the command line.
+	literalPassword string
+	// filePassword is the path of a file containing the password.
+	filePassword string
+	// envPassword is the name of an environment variable containing the password.
+	envPassword string
+	// fdPassword is an open file descriptor to read the password from.
+	fdPassword uintptr
+	// promptPassword asks for the password on the terminal.
+	promptPassword string
+)
+
+// commandPassword is a shell command that prints the password.
+type commandPassword struct {
+	command string
+	// noStdin keeps the command from reading stdin, for when a backup is read from there. Otherwise
+	// it's passed on, as password managers may need to ask for their own passphrase.
+	noStdin bool
+}
+
+func (p literalPassword) read() (string, error) {
+	return string(p), nil
+}
+
+func (p filePassword) read() (string, error) {
+	bs, err := ioutil.ReadFile(string(p))
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read file")
+	}
+	return string(bs), nil
+}
+
+func (p envPassword) read() (string, error) {
+	return os.Getenv(string(p)), nil
+}
+
+func (p commandPassword) read() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", p.command)
+	} else {
+		cmd = exec.Command("sh", "-c", p.command)
+	}
+	if !p.noStdin {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrap(err, "password command failed")
+	}
+	return string(out), nil
+}
+
+func (p fdPassword) read() (string, error) {
+	f := os.NewFile(uintptr(p), fmt.Sprintf("fd %d", p))
+	if f == nil {
+		return "", errors.Errorf("file descriptor %d is not open", p)
+	}
+	defer f.Close()
+
+	// Only read a line, so that the other end doesn't have to close it.
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.Wrapf(err, "unable to read from file descriptor %d", p)
+	}
+	return line, nil
+}
+
+func (p promptPassword) read() (string, error) {
+	fmt.Fprint(os.Stderr, string(p))
+	raw, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read from stdin")
+	}
+	return string(raw), nil
+}
+
+func (literalPassword) prompts() bool { return false }
+func (filePassword) prompts() bool    { return false }
+func (envPassword) prompts() bool     { return false }
+func (commandPassword) prompts() bool { return false }
+func (fdPassword) prompts() bool      { return false }
+func (promptPassword) prompts() bool  { return true }
+
+// passwordSourceFor returns where to read the password for the backup given to a command. Only
+// one of the password flags can be used. Without any of them, the password is read from
+// SIGNAL_BACK_PASSWORD if it's set, or otherwise asked for.
+func passwordSourceFor(c *cli.Context) (passwordSource, error) {
+	sources, err := passwordFlagSources(c, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(sources) {
+	case 0:
+		return defaultPasswordSource("Password: "), nil
+	case 1:
+		return sources[0], nil
+	}
+	return nil, errors.New("only one of --password, --pwdfile, --password-command and --password-fd can be used")
+}
+
+// newPasswordSourceFor returns where to read a second password from, given by the flags that start
+// with --new- the same way as the password flags, or by SIGNAL_BACK_NEW_PASSWORD. If there isn't
+// one, it returns nil, and the password is up to the command.
+func newPasswordSourceFor(c *cli.Context) (passwordSource, error) {
+	sources, err := passwordFlagSources(c, "new-")
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(sources) {
+	case 0:
+		if os.Getenv(newPasswordEnv) != "" {
+			return envPassword(newPasswordEnv), nil
+		}
+		return nil, nil
+	case 1:
+		return sources[0], nil
+	}
+	return nil, errors.New("only one of --new-password, --new-pwdfile, --new-password-command and --new-password-fd can be used")
+}
+
+// newPasswordGiven reports whether a second password has been given at all. A mistake in how it
+// was given counts, so that it's reported when the password is read.
+func newPasswordGiven(c *cli.Context) bool {
+	src, err := newPasswordSourceFor(c)
+	return src != nil || err != nil
+}
+
+// passwordFlagSources returns the sources given by the password flags whose names start with
+// prefix.
+func passwordFlagSources(c *cli.Context, prefix string) ([]passwordSource, error) {
+	var sources []passwordSource
+	if pass := c.String(prefix + "password"); pass != "" {
+		sources = append(sources, literalPassword(pass))
+	}
+	if file := c.String(prefix + "pwdfile"); file != "" {
+		sources = append(sources, filePassword(file))
+	}
+	if command := c.String(prefix + "password-command"); command != "" {
+		sources = append(sources, commandPassword{command: command, noStdin: backupFromStdin(c)})
+	}
+	if c.IsSet(prefix + "password-fd") {
+		fd := c.Int(prefix + "password-fd")
+		if fd < 0 {
+			return nil, errors.Errorf("--%spassword-fd must be a file descriptor", prefix)
+		}
+		sources = append(sources, fdPassword(fd))
+	}
+	return sources, nil
+}
+
+// backupFromStdin reports whether any of the backups given to a command is read from stdin.
+func backupFromStdin(c *cli.Context) bool {
+	for _, arg := range c.Args() {
+		if arg == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultPasswordSource reads the password from SIGNAL_BACK_PASSWORD if it's set, or otherwise
+// asks for it with prompt.
+func defaultPasswordSource(prompt string) passwordSource {
+	if os.Getenv(passwordEnv) != "" {
+		return envPassword(passwordEnv)
+	}
+	return promptPassword(prompt)
+}
+
+// readsStdin reports whether reading from src uses stdin, so that the backup can't.
+func readsStdin(src passwordSource) bool {
+	fd, ok := src.(fdPassword)
+	return src.prompts() || (ok && fd == 0)
+}
+
+// readPasswordSource reads a password from src, and checks that it's in the format of a backup
+// passphrase before anything slow is done with it.
+func readPasswordSource(src passwordSource) (string, error) {
+	pass, err := src.read()
+	if err != nil {
+		return "", err
+	}
+	return types.NormalisePassphrase(pass)
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/types"
+)
+
+// errPoolStopped is returned when an attachment is handed to a pool that has already failed.
+var errPoolStopped = errors.New("extraction stopped")
+
+// attachmentPool decrypts attachments concurrently, while the backup is read through for more of
+// them. Attachments are written by a set of workers, but are finished off in the order they were
+// found, so that what is logged and which error is reported don't depend on which worker is
+// fastest.
+type attachmentPool struct {
+	bf *types.BackupFile
+
+	// Attachments waiting for a worker.
+	jobs chan *attachmentJob
+	// Attachments that haven't been finished off yet, in the order they were found.
+	pending chan *attachmentJob
+	// Closed once an attachment has failed.
+	stop chan struct{}
+	// The result of the whole pool.
+	done chan error
+
+	seen map[uint64]bool
+}
+
+type attachmentJob struct {
+	fileName string
+	hasMime  bool
+	pos      types.AttachmentPosition
+	err      chan error
+}
+
+// newAttachmentPool starts a pool of n workers decrypting attachments from bf.
+func newAttachmentPool(bf *types.BackupFile, n int) *attachmentPool {
+	p := &attachmentPool{
+		bf:      bf,
+		jobs:    make(chan *attachmentJob),
+		pending: make(chan *attachmentJob, n),
+		stop:    make(chan struct{}),
+		done:    make(chan error, 1),
+		seen:    map[uint64]bool{},
+	}
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	go p.finish()
+	return p
+}
+
+// submit skips over the attachment next in the backup, and hands it to a worker to decrypt.
+func (p *attachmentPool) submit(id uint64, mime string, hasMime bool, length uint32) error {
+	pos, err := p.bf.SkipAttachment(length)
+	if err != nil {
+		return errors.Wrap(err, "failed to skip attachment")
+	}
+
+	// Two workers writing the same file would leave it in any state.
+	if p.seen[id] {
+		log.Printf("skipping attachment %v, which has already been extracted\n", id)
+		return nil
+	}
+	p.seen[id] = true
+
+	select {
+	case <-p.stop:
+		return errPoolStopped
+	default:
+	}
+
+	j := &attachmentJob{
+		fileName: attachmentFileName(id, mime),
+		hasMime:  hasMime,
+		pos:      pos,
+		err:      make(chan error, 1),
+	}
+	p.pending <- j
+	p.jobs <- j
+	return nil
+}
+
+// wait waits for every attachment that has been submitted, and returns the error of the first
+// one that failed.
+func (p *attachmentPool) wait() error {
+	close(p.jobs)
+	close(p.pending)
+	return <-p.done
+}
+
+func (p *attachmentPool) work() {
+	for j := range p.jobs {
+		j.err <- writeAttachment(j.fileName, func(out io.Writer) error {
+			return p.bf.DecryptAttachmentAt(j.pos, out)
+		})
+	}
+}
+
+func (p *attachmentPool) finish() {
+	var err error
+	for j := range p.pending {
+		jerr := <-j.err
+		if err != nil {
+			continue
+		}
+		if jerr == nil {
+			jerr = nameAttachment(j.fileName, j.hasMime)
+		}
+		if jerr != nil {
+			err = jerr
+			close(p.stop)
+		}
+	}
+	p.done <- err
+}
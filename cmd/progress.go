@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/types"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var progressFlag = cli.BoolFlag{
+	Name:  "no-progress",
+	Usage: "don't report progress on stderr",
+}
+
+// progressLogInterval is how often progress is logged when stderr isn't a terminal.
+const progressLogInterval = 10 * time.Second
+
+// progressBarWidth is the number of characters in the bar itself.
+const progressBarWidth = 30
+
+// showProgress reports the progress of reading bf on stderr: as a progress bar if stderr is a
+// terminal and nothing else is logged there, and as occasional lines otherwise.
+func showProgress(c *cli.Context, bf *types.BackupFile, logging bool) {
+	if c.Bool("no-progress") {
+		return
+	}
+
+	// Log lines would be written over the top of the bar.
+	if terminal.IsTerminal(int(os.Stderr.Fd())) && !logging {
+		bf.OnProgress = progressBar(os.Stderr)
+	} else {
+		bf.OnProgress = progressLog(log.New(os.Stderr, "", log.LstdFlags))
+	}
+}
+
+// progressBar redraws a progress bar on a single line of w.
+func progressBar(w io.Writer) func(types.Progress) {
+	return func(p types.Progress) {
+		line := "\r"
+		if f := p.Fraction(); f >= 0 {
+			filled := int(f * progressBarWidth)
+			line += fmt.Sprintf("[%s%s] %3.0f%% ", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), f*100)
+		}
+		line += progressSummary(p)
+		if p.Done {
+			line += "\n"
+		}
+		// Clear what's left of a longer line from before.
+		fmt.Fprintf(w, "%s\x1b[K", line)
+	}
+}
+
+// progressLog logs the progress every progressLogInterval, and once it's done.
+func progressLog(l *log.Logger) func(types.Progress) {
+	var last time.Duration
+	return func(p types.Progress) {
+		if !p.Done && p.Elapsed-last < progressLogInterval {
+			return
+		}
+		last = p.Elapsed
+
+		if f := p.Fraction(); f >= 0 {
+			l.Printf("progress: %.0f%%, %s\n", f*100, progressSummary(p))
+		} else {
+			l.Printf("progress: %s\n", progressSummary(p))
+		}
+	}
+}
+
+func progressSummary(p types.Progress) string {
+	s := formatBytes(p.BytesRead)
+	if p.TotalBytes > 0 {
+		s += "/" + formatBytes(p.TotalBytes)
+	}
+	s += fmt.Sprintf(", %d frames, %d attachments", p.Frames, p.Attachments)
+	if p.Done {
+		s += fmt.Sprintf(", took %s", p.Elapsed.Round(time.Second))
+	} else if eta := p.ETA(); eta > 0 {
+		s += fmt.Sprintf(", %s left", eta.Round(time.Second))
+	}
+	return s
+}
+
+// formatBytes formats a size using the same suffixes parseSize accepts.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
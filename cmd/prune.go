@@ -0,0 +1,454 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+// Prune fulfils the `prune` subcommand.
+var Prune = cli.Command{
+	Name:               "prune",
+	Usage:              "Remove messages or attachments from the backup",
+	UsageText:          "Write a copy of the backup to OUTFILE without the selected threads, messages or attachments.\nThe copy is encrypted with the same password unless a new one is given.",
+	ArgsUsage:          "BACKUPFILE OUTFILE",
+	CustomHelpTemplate: SubcommandHelp,
+	Flags: append([]cli.Flag{
+		cli.Int64SliceFlag{
+			Name:  "thread",
+			Usage: "remove the thread with `ID`, and all its messages",
+		},
+		cli.StringFlag{
+			Name:  "before",
+			Usage: "remove messages received before `DATE` (YYYY-MM-DD or RFC 3339)",
+		},
+		cli.StringFlag{
+			Name:  "max-size",
+			Usage: "remove attachments larger than `SIZE` bytes (K, M and G suffixes are allowed)",
+		},
+		cli.StringSliceFlag{
+			Name:  "mime",
+			Usage: "remove attachments of MIME `TYPE`, such as video/mp4 or video/*",
+		},
+		cli.StringFlag{
+			Name:  "new-password",
+			Usage: "use `PASS` as password for the new backup file",
+		},
+		cli.StringFlag{
+			Name:  "new-pwdfile",
+			Usage: "read password for the new backup file from `FILE`",
+		},
+	}, append(newPasswordFlags("the new backup file"), coreFlags...)...),
+	Action: func(c *cli.Context) error {
+		out := c.Args().Get(1)
+		if out == "" {
+			return errors.New("must specify an output file")
+		}
+		if _, err := os.Stat(out); err == nil {
+			return errors.Errorf("%s already exists", out)
+		}
+
+		p, err := newPruner(c)
+		if err != nil {
+			return err
+		}
+
+		bf, pass, err := setupWithPassword(c)
+		if err != nil {
+			return err
+		}
+		defer bf.Close()
+
+		// Without the password, the new backup needs one of its own.
+		if pass == "" || newPasswordGiven(c) {
+			if pass, err = readNewPassword(c); err != nil {
+				return errors.Wrap(err, "unable to read new password")
+			}
+		}
+
+		if err = pruneBackup(bf, out, pass, p); err != nil {
+			return errors.Wrap(err, "failed to prune backup")
+		}
+
+		log.Printf("removed %d messages, %d attachments and %d rows that referred to them\n", p.messages, p.attachments, p.references)
+		return nil
+	},
+}
+
+// pruner decides which rows of a backup to remove.
+type pruner struct {
+	threads map[uint64]bool
+	before  uint64
+	maxSize uint64
+	mimes   []string
+
+	// Rows removed so far, so that the rows and attachments that refer to them can be too.
+	sms   map[uint64]bool
+	mms   map[uint64]bool
+	parts map[uint64]bool
+
+	// What is left of each thread, so that the summaries of threads that have had messages removed
+	// can be brought up to date.
+	summaries threadSummaries
+
+	messages    int
+	attachments int
+	references  int
+}
+
+// threadSummary is what the thread table records about a thread's messages.
+type threadSummary struct {
+	// Whether any of the thread's messages have been removed, and whether the snippet is stale: that
+	// is, the latest of them wasn't kept.
+	pruned bool
+	newest uint64
+	stale  bool
+
+	count  uint64
+	unread uint64
+
+	// The latest message left in the thread.
+	date        uint64
+	body        *string
+	snippetType *uint64
+}
+
+// remove records that one of the thread's messages has been removed.
+func (s *threadSummary) remove(date *uint64) {
+	s.pruned = true
+	if date != nil && *date >= s.newest {
+		s.newest, s.stale = *date, true
+	}
+}
+
+// keep adds a message that hasn't been removed to the summary.
+func (s *threadSummary) keep(date *uint64, body *string, snippetType *uint64, read uint64) {
+	s.count++
+	if read == 0 {
+		s.unread++
+	}
+	if date != nil && *date >= s.date {
+		s.date, s.body, s.snippetType = *date, body, snippetType
+	}
+	if date != nil && *date >= s.newest {
+		s.newest, s.stale = *date, false
+	}
+}
+
+// update brings a row of the thread table up to date with the summary. The snippet is only
+// changed if it's stale, as the app records more about it than can be worked out again here, and
+// is cleared if no messages are left.
+func (s *threadSummary) update(r *types.Row) {
+	r.SetInteger("message_count", s.count)
+	r.SetInteger("unread_count", s.unread)
+	if !s.stale {
+		return
+	}
+
+	if s.count > 0 {
+		r.SetInteger("date", s.date)
+	}
+	r.SetString("snippet", s.body)
+	if s.snippetType != nil {
+		r.SetInteger("snippet_type", *s.snippetType)
+	}
+	for _, column := range []string{"snippet_uri", "snippet_content_type", "snippet_extras"} {
+		r.SetString(column, nil)
+	}
+}
+
+// messageReference is a column of another table that refers to a message.
+type messageReference struct {
+	column string
+	// Either whether the column refers to the mms table rather than sms, or the column that says
+	// so.
+	mms   bool
+	isMMS string
+}
+
+// message returns the ID of the message a row refers to, and whether it's in the mms table.
+func (ref messageReference) message(r *types.Row) (uint64, bool) {
+	mms := ref.mms
+	if ref.isMMS != "" {
+		mms = r.Param(ref.isMMS).GetIntegerParameter() != 0
+	}
+	return r.Param(ref.column).GetIntegerParameter(), mms
+}
+
+// messageReferences are the columns that refer to messages, by table. Rows that refer to a removed
+// message are removed with it.
+var messageReferences = map[string]messageReference{
+	"group_receipts": {column: "mms_id", mms: true},
+	"mention":        {column: "message_id", mms: true},
+	"story_sends":    {column: "message_id", mms: true},
+	"reaction":       {column: "message_id", isMMS: "is_mms"},
+	"msl_message":    {column: "message_id", isMMS: "is_mms"},
+}
+
+func newPruner(c *cli.Context) (*pruner, error) {
+	p := &pruner{
+		threads: map[uint64]bool{},
+		mimes:   c.StringSlice("mime"),
+		sms:     map[uint64]bool{},
+		mms:     map[uint64]bool{},
+		parts:   map[uint64]bool{},
+
+		summaries: threadSummaries{},
+	}
+
+	for _, id := range c.Int64Slice("thread") {
+		p.threads[uint64(id)] = true
+	}
+
+	if s := c.String("before"); s != "" {
+		t, err := parseDate(s)
+		if err != nil {
+			return nil, err
+		}
+		p.before = uint64(t.UnixNano() / int64(time.Millisecond))
+	}
+
+	if s := c.String("max-size"); s != "" {
+		size, err := parseSize(s)
+		if err != nil {
+			return nil, err
+		}
+		p.maxSize = size
+	}
+
+	if len(p.threads) == 0 && p.before == 0 && p.maxSize == 0 && len(p.mimes) == 0 {
+		return nil, errors.New("nothing to prune; use --thread, --before, --max-size or --mime")
+	}
+	return p, nil
+}
+
+// pruneBackup writes a copy of a backup, encrypted with pass, to the file at path without the rows
+// selected by p.
+//
+// Rows are removed as they're read, so messages have to come before their parts, the rows that
+// refer to them, and their threads in the backup. The Signal app always writes them in that order.
+func pruneBackup(bf *types.BackupFile, path, pass string, p *pruner) error {
+	return writeBackup(path, pass, bf.Version, func(bw *types.BackupWriter) (int, error) {
+		var frames int
+		write := func(f *signal.BackupFrame) error {
+			frames++
+			return bw.WriteFrame(f)
+		}
+
+		discard := types.DiscardConsumeFuncs(bf)
+		fns := types.ConsumeFuncs{
+			VersionFunc: func(v *signal.DatabaseVersion) error {
+				return write(&signal.BackupFrame{Version: v})
+			},
+			EndFunc: func() error {
+				return write(&signal.BackupFrame{End: proto.Bool(true)})
+			},
+			StatementFunc: func(s *signal.SqlStatement) error {
+				drop, err := p.drop(bf, s)
+				if err != nil || drop {
+					return err
+				}
+				return write(&signal.BackupFrame{Statement: s})
+			},
+			PreferenceFunc: func(pref *signal.SharedPreference) error {
+				return write(&signal.BackupFrame{Preference: pref})
+			},
+			KeyValueFunc: func(kv *signal.KeyValue) error {
+				return write(&signal.BackupFrame{KeyValue: kv})
+			},
+			AttachmentFunc: func(a *signal.Attachment) error {
+				if p.parts[a.GetRowId()] {
+					return discard.AttachmentFunc(a)
+				}
+				if err := write(&signal.BackupFrame{Attachment: a}); err != nil {
+					return err
+				}
+				return copyAttachment(bf, bw, a.GetLength())
+			},
+			AvatarFunc: func(a *signal.Avatar) error {
+				if err := write(&signal.BackupFrame{Avatar: a}); err != nil {
+					return err
+				}
+				return copyAttachment(bf, bw, a.GetLength())
+			},
+			StickerFunc: func(s *signal.Sticker) error {
+				if err := write(&signal.BackupFrame{Sticker: s}); err != nil {
+					return err
+				}
+				return copyAttachment(bf, bw, s.GetLength())
+			},
+		}
+
+		return frames, bf.Consume(fns)
+	})
+}
+
+// drop reports whether a statement inserts a row that should be removed.
+func (p *pruner) drop(bf *types.BackupFile, stmt *signal.SqlStatement) (bool, error) {
+	if !isInsert(stmt) {
+		return false, nil
+	}
+	r, err := bf.Schema.Row(stmt)
+	if err != nil {
+		return false, err
+	}
+
+	switch r.Table {
+	case "thread":
+		id := r.Param("_id").GetIntegerParameter()
+		if p.threads[id] {
+			return true, nil
+		}
+		// Threads left without any messages are kept, as rows that have already been written, such
+		// as drafts, can still refer to them.
+		if s := p.summaries[id]; s != nil && s.pruned {
+			s.update(r)
+		}
+
+	case "sms":
+		sms, err := types.RowToSMS(r)
+		if err != nil {
+			return false, err
+		}
+		s := p.summaries.get(sms.ThreadID)
+		if p.dropMessage(sms.ThreadID, sms.DateReceived) {
+			p.sms[sms.ID] = true
+			s.remove(sms.DateReceived)
+			p.messages++
+			return true, nil
+		}
+		s.keep(sms.DateReceived, sms.Body, sms.Type, sms.Read)
+
+	case "mms":
+		mms, err := types.RowToMMS(r)
+		if err != nil {
+			return false, err
+		}
+		s := p.summaries.get(mms.ThreadID)
+		if p.dropMessage(mms.ThreadID, mms.DateReceived) {
+			p.mms[mms.ID] = true
+			s.remove(mms.DateReceived)
+			p.messages++
+			return true, nil
+		}
+		s.keep(mms.DateReceived, mms.Body, mms.MessageBox, mms.Read)
+
+	case "part":
+		part, err := types.RowToPart(r)
+		if err != nil {
+			return false, err
+		}
+		if p.dropPart(part) {
+			p.parts[part.RowID] = true
+			p.attachments++
+			return true, nil
+		}
+
+	default:
+		if p.dropReference(r) {
+			p.references++
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// threadSummaries are the summaries of threads by ID.
+type threadSummaries map[uint64]*threadSummary
+
+// get returns the summary of a thread, or a throwaway one if the message has no thread.
+func (ss threadSummaries) get(thread *uint64) *threadSummary {
+	if thread == nil {
+		return &threadSummary{}
+	}
+	s, ok := ss[*thread]
+	if !ok {
+		s = &threadSummary{}
+		ss[*thread] = s
+	}
+	return s
+}
+
+// dropReference reports whether a row refers to a message or thread that has been removed.
+func (p *pruner) dropReference(r *types.Row) bool {
+	if ref, ok := messageReferences[r.Table]; ok && r.Has(ref.column) {
+		id, mms := ref.message(r)
+		if (mms && p.mms[id]) || (!mms && p.sms[id]) {
+			return true
+		}
+	}
+
+	// Such as drafts, which belong to a thread rather than a message.
+	if thread := r.Integer("thread_id"); thread != nil && p.threads[*thread] {
+		return true
+	}
+	return false
+}
+
+func (p *pruner) dropMessage(thread, date *uint64) bool {
+	if thread != nil && p.threads[*thread] {
+		return true
+	}
+	return p.before > 0 && date != nil && *date < p.before
+}
+
+func (p *pruner) dropPart(part *types.SQLPart) bool {
+	if part.MmsID != nil && p.mms[*part.MmsID] {
+		return true
+	}
+	if p.maxSize > 0 && part.Size != nil && *part.Size > p.maxSize {
+		return true
+	}
+	if part.ContentType != nil {
+		for _, pattern := range p.mimes {
+			if ok, _ := path.Match(pattern, *part.ContentType); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseDate reads a date either as YYYY-MM-DD in local time, or as an RFC 3339 timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid date %s; use YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// parseSize reads a number of bytes, with an optional K, M or G suffix for powers of 1024.
+func parseSize(s string) (uint64, error) {
+	var shift uint
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %s", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/types"
+)
+
+// Rekey fulfils the `rekey` subcommand.
+var Rekey = cli.Command{
+	Name:               "rekey",
+	Usage:              "Re-encrypt the backup under a new password",
+	UsageText:          "Decrypt the backup and encrypt it again with a new password, salt and IV, writing it to\nOUTFILE. Nothing is written to disk unencrypted.",
+	ArgsUsage:          "BACKUPFILE OUTFILE",
+	CustomHelpTemplate: SubcommandHelp,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "new-password",
+			Usage: "use `PASS` as password for the new backup file",
+		},
+		cli.StringFlag{
+			Name:  "new-pwdfile",
+			Usage: "read password for the new backup file from `FILE`",
+		},
+	}, append(newPasswordFlags("the new backup file"), coreFlags...)...),
+	Action: func(c *cli.Context) error {
+		out := c.Args().Get(1)
+		if out == "" {
+			return errors.New("must specify an output file")
+		}
+		if _, err := os.Stat(out); err == nil {
+			return errors.Errorf("%s already exists", out)
+		}
+
+		bf, err := setup(c)
+		if err != nil {
+			return err
+		}
+		defer bf.Close()
+
+		newPass, err := readNewPassword(c)
+		if err != nil {
+			return errors.Wrap(err, "unable to read new password")
+		}
+
+		if err = Reencrypt(bf, out, newPass); err != nil {
+			return errors.Wrap(err, "failed to rekey backup")
+		}
+		return nil
+	},
+}
+
+// readNewPassword reads the password for the new backup, asking for it twice if it's entered at
+// the prompt.
+func readNewPassword(c *cli.Context) (string, error) {
+	src, err := newPasswordSourceFor(c)
+	if err != nil {
+		return "", err
+	}
+	// The prompt reads from stdin, so it can't be used when the backup does too.
+	if backupFromStdin(c) && (src == nil || readsStdin(src)) {
+		return "", errors.New("must give the new password another way when reading the backup from stdin")
+	}
+	if src != nil {
+		return readPasswordSource(src)
+	}
+
+	pass, err := readPasswordSource(promptPassword("New password: "))
+	if err != nil {
+		return "", err
+	}
+	confirm, err := readPasswordSource(promptPassword("Confirm new password: "))
+	if err != nil {
+		return "", err
+	}
+	if pass != confirm {
+		return "", errors.New("passwords do not match")
+	}
+	return pass, nil
+}
+
+// Reencrypt encrypts a backup again with pass and writes it to the file at path.
+func Reencrypt(bf *types.BackupFile, path, pass string) error {
+	return writeBackup(path, pass, bf.Version, func(bw *types.BackupWriter) (int, error) {
+		return copyFrames(bf, bw)
+	})
+}
+
+// copyFrames copies every frame and attachment from bf to bw, returning how many frames were
+// copied. If bw is nil, the frames are only read.
+func copyFrames(bf *types.BackupFile, bw *types.BackupWriter) (int, error) {
+	var count int
+
+	for {
+		f, err := bf.Frame()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		count++
+
+		if bw != nil {
+			if err = bw.WriteFrame(f); err != nil {
+				return count, err
+			}
+		}
+
+		length := types.FrameDataLength(f)
+		if length == 0 {
+			continue
+		}
+
+		if bw == nil {
+			err = bf.DecryptAttachment(length, ioutil.Discard)
+		} else {
+			err = copyAttachment(bf, bw, length)
+		}
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to copy data for frame %d", count-1)
+		}
+	}
+}
+
+// copyAttachment streams an attachment from bf to bw without buffering all of it.
+func copyAttachment(bf *types.BackupFile, bw *types.BackupWriter, length uint32) error {
+	r, err := bf.AttachmentReader(length)
+	if err != nil {
+		return err
+	}
+	if err = bw.EncryptAttachment(length, r); err != nil {
+		return err
+	}
+
+	// The MAC is only checked once the reader reaches the end.
+	if _, err = r.Read(make([]byte, 1)); err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+const (
+	testPassword    = "123451234512345123451234512345"
+	testNewPassword = "543215432154321543215432154321"
+)
+
+var testFrames = []*signal.BackupFrame{
+	{Version: &signal.DatabaseVersion{Version: proto.Uint32(42)}},
+	{Statement: testStatement("CREATE TABLE sms (_id INTEGER PRIMARY KEY, thread_id INTEGER, address INTEGER, date INTEGER, date_sent INTEGER, type INTEGER, body TEXT)")},
+	{Statement: testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 1, 1, 1, 1005, 1000, 87, "hi alice")},
+	{Preference: &signal.SharedPreference{
+		File:  proto.String("org.thoughtcrime.securesms_preferences"),
+		Key:   proto.String("pref_theme"),
+		Value: proto.String("dark"),
+	}},
+	{Attachment: &signal.Attachment{RowId: proto.Uint64(1), AttachmentId: proto.Uint64(1000), Length: proto.Uint32(3000)}},
+	{Avatar: &signal.Avatar{Name: proto.String("+61400000001"), Length: proto.Uint32(100)}},
+	{End: proto.Bool(true)},
+}
+
+// testPayload is the data written after the frame at index i.
+func testPayload(i int, length uint32) []byte {
+	payload := make([]byte, length)
+	for j := range payload {
+		payload[j] = byte(i*31 + j)
+	}
+	return payload
+}
+
+// writeTestBackupFile writes testFrames to a backup file at path, encrypted with pass.
+func writeTestBackupFile(t *testing.T, path, pass string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	bw, err := types.NewBackupWriter(f, pass, nil, nil, 0)
+	if err != nil {
+		t.Fatalf("NewBackupWriter: %v", err)
+	}
+	for i, frame := range testFrames {
+		if err = bw.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame %d: %v", i, err)
+		}
+		if length := types.FrameDataLength(frame); length > 0 {
+			if err = bw.EncryptAttachment(length, bytes.NewReader(testPayload(i, length))); err != nil {
+				t.Fatalf("EncryptAttachment %d: %v", i, err)
+			}
+		}
+	}
+}
+
+// checkTestBackupFile decrypts the backup file at path with pass, and checks that it holds
+// testFrames and their data.
+func checkTestBackupFile(t *testing.T, path, pass string) {
+	bf, err := types.NewBackupFile(path, pass)
+	if err != nil {
+		t.Fatalf("NewBackupFile: %v", err)
+	}
+	defer bf.Close()
+
+	for i := 0; ; i++ {
+		f, err := bf.Frame()
+		if err == io.EOF {
+			if i != len(testFrames) {
+				t.Fatalf("got %d frames, want %d", i, len(testFrames))
+			}
+			return
+		} else if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if i >= len(testFrames) || !proto.Equal(f, testFrames[i]) {
+			t.Fatalf("frame %d is %v", i, f)
+		}
+
+		if length := types.FrameDataLength(f); length > 0 {
+			var out bytes.Buffer
+			if err = bf.DecryptAttachment(length, &out); err != nil {
+				t.Fatalf("data of frame %d: %v", i, err)
+			}
+			if !bytes.Equal(out.Bytes(), testPayload(i, length)) {
+				t.Fatalf("data of frame %d differs", i)
+			}
+		}
+	}
+}
+
+func TestReencryptRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signal-back-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.backup"), filepath.Join(dir, "out.backup")
+	writeTestBackupFile(t, in, testPassword)
+	checkTestBackupFile(t, in, testPassword)
+
+	bf, err := types.NewBackupFile(in, testPassword)
+	if err != nil {
+		t.Fatalf("NewBackupFile: %v", err)
+	}
+	err = Reencrypt(bf, out, testNewPassword)
+	bf.Close()
+	if err != nil {
+		t.Fatalf("Reencrypt: %v", err)
+	}
+
+	checkTestBackupFile(t, out, testNewPassword)
+
+	// The old password no longer opens it.
+	if old, err := types.NewBackupFile(out, testPassword); err == nil {
+		_, err = copyFrames(old, nil)
+		old.Close()
+		if err == nil {
+			t.Error("rekeyed backup can still be read with the old password")
+		}
+	}
+}
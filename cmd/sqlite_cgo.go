@@ -0,0 +1,10 @@
+//go:build cgo
+// +build cgo
+
+package cmd
+
+// requireSQLite returns an error if this build can't use SQLite. go-sqlite3 needs cgo, which this
+// build has.
+func requireSQLite() error {
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !cgo
+// +build !cgo
+
+package cmd
+
+import "github.com/pkg/errors"
+
+// requireSQLite returns an error if this build can't use SQLite. go-sqlite3 needs cgo, and without
+// it only has a stub that fails once the database is opened, after the backup has been read.
+func requireSQLite() error {
+	return errors.New("this build of signal-back was built without cgo, so it can't use SQLite; build it from source with cgo enabled")
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+var sinceStateFlag = cli.StringFlag{
+	Name:  "since-state",
+	Usage: "only export messages and attachments newer than those recorded in `FILE`, then record the newest ones there",
+}
+
+// exportState is the state file used for incremental exports. It records the newest message and
+// attachment that have been exported, so that later exports can leave them out.
+type exportState struct {
+	// The latest date a message was received, in milliseconds since the epoch, and the messages
+	// received then, by messageID. More messages can arrive in the same millisecond after the
+	// export.
+	MessageDate uint64   `json:"message_date"`
+	MessageIDs  []string `json:"message_ids,omitempty"`
+	// The largest attachment ID, which is when the attachment was created.
+	AttachmentID uint64 `json:"attachment_id"`
+}
+
+// incrementalExport filters a backup down to what is newer than a state file, and updates the
+// state file once the export has finished.
+type incrementalExport struct {
+	path  string
+	since exportState
+	seen  exportState
+
+	// Rows of the backup that are being exported, so that the rows and attachments that belong to
+	// them are too.
+	mms   map[uint64]bool
+	parts map[uint64]bool
+}
+
+// startIncrementalExport loads the state file at path, and sets a filter on bf so that only newer
+// messages and attachments are read from it. If the state file doesn't exist yet, everything is
+// read. If path is empty, nothing is done and nil is returned.
+func startIncrementalExport(bf *types.BackupFile, path string) (*incrementalExport, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	// Commands may change directory before writing their output.
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find state file")
+	}
+
+	e := &incrementalExport{
+		path:  path,
+		mms:   map[uint64]bool{},
+		parts: map[uint64]bool{},
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("no state file at %s; exporting everything\n", path)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "unable to read state file")
+	} else if err = json.Unmarshal(bs, &e.since); err != nil {
+		return nil, errors.Wrap(err, "unable to read state file")
+	}
+	e.seen = e.since
+
+	bf.Filter = e.filter(bf)
+	return e, nil
+}
+
+// filter reports whether a frame should be exported. Messages are exported if they were received
+// after the last export, and attachments if they belong to an exported message or were created
+// after the last export.
+func (e *incrementalExport) filter(bf *types.BackupFile) func(*signal.BackupFrame) bool {
+	return func(f *signal.BackupFrame) bool {
+		if a := f.GetAttachment(); a != nil {
+			id := a.GetAttachmentId()
+			if id > e.seen.AttachmentID {
+				e.seen.AttachmentID = id
+			}
+			return e.parts[a.GetRowId()] || id > e.since.AttachmentID
+		}
+
+		stmt := f.GetStatement()
+		if stmt == nil || !isInsert(stmt) {
+			return true
+		}
+		// Rows that can't be decoded are left for the output format to report.
+		r, err := bf.Schema.Row(stmt)
+		if err != nil {
+			return true
+		}
+
+		switch r.Table {
+		case "sms":
+			sms, err := types.RowToSMS(r)
+			if err != nil {
+				return true
+			}
+			return e.message(r, sms.DateReceived, sms.DateSent)
+
+		case "mms":
+			mms, err := types.RowToMMS(r)
+			if err != nil {
+				return true
+			}
+			if !e.message(r, mms.DateReceived, mms.DateSent) {
+				return false
+			}
+			e.mms[mms.ID] = true
+			return true
+
+		case "part":
+			part, err := types.RowToPart(r)
+			if err != nil {
+				return true
+			}
+			if part.UniqueID > e.seen.AttachmentID {
+				e.seen.AttachmentID = part.UniqueID
+			}
+			if (part.MmsID != nil && e.mms[*part.MmsID]) || part.UniqueID > e.since.AttachmentID {
+				e.parts[part.RowID] = true
+				return true
+			}
+			return false
+		}
+
+		return true
+	}
+}
+
+// message records a message's date, and reports whether it's newer than the last export. Messages
+// received in the same millisecond as the newest one last time are new if they weren't exported
+// then.
+func (e *incrementalExport) message(r *types.Row, received, sent *uint64) bool {
+	date := received
+	if date == nil {
+		date = sent
+	}
+	if date == nil {
+		return true
+	}
+
+	id, err := messageID(r)
+	if err != nil {
+		return true
+	}
+	if *date > e.seen.MessageDate {
+		e.seen.MessageDate, e.seen.MessageIDs = *date, nil
+	}
+	if *date == e.seen.MessageDate && !containsString(e.seen.MessageIDs, id) {
+		e.seen.MessageIDs = append(e.seen.MessageIDs, id)
+	}
+
+	if *date == e.since.MessageDate {
+		return !containsString(e.since.MessageIDs, id)
+	}
+	return *date > e.since.MessageDate
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// messageID identifies a message in the state file. It's a hash of its messageKey, so that what
+// the message said isn't kept there.
+func messageID(r *types.Row) (string, error) {
+	key, err := messageKey(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// finish records the newest message and attachment that were seen in the state file. It should
+// only be called once the export has succeeded.
+func (e *incrementalExport) finish() error {
+	if e == nil {
+		return nil
+	}
+
+	bs, err := json.MarshalIndent(e.seen, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode state file")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(e.path), ".signal-back-state-")
+	if err != nil {
+		return errors.Wrap(err, "unable to write state file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(bs, '\n')); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to write state file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "unable to write state file")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), e.path), "unable to write state file")
+}
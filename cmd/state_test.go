@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/xeals/signal-back/signal"
+	"github.com/xeals/signal-back/types"
+)
+
+// testExport runs statements through the filter of an incremental export that starts from since,
+// and returns the bodies of the messages it lets through.
+func testExport(t *testing.T, since exportState, stmts ...*signal.SqlStatement) (*incrementalExport, []string) {
+	bf := &types.BackupFile{Schema: types.NewSchema()}
+	e := &incrementalExport{since: since, seen: since, mms: map[uint64]bool{}, parts: map[uint64]bool{}}
+	filter := e.filter(bf)
+
+	var bodies []string
+	for _, stmt := range stmts {
+		if err := bf.Schema.Observe(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt.GetStatement(), err)
+		}
+		if !filter(&signal.BackupFrame{Statement: stmt}) || !isInsert(stmt) {
+			continue
+		}
+		r, err := bf.Schema.Row(stmt)
+		if err != nil {
+			t.Fatalf("%s: %v", stmt.GetStatement(), err)
+		}
+		bodies = append(bodies, *r.String("body"))
+	}
+	return e, bodies
+}
+
+func TestIncrementalExportSameMillisecond(t *testing.T) {
+	create := testStatement("CREATE TABLE sms (_id INTEGER PRIMARY KEY, thread_id INTEGER, address INTEGER, date INTEGER, date_sent INTEGER, type INTEGER, body TEXT)")
+	first := testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 1, 1, 1, 1005, 1000, 87, "first")
+	older := testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 2, 1, 1, 900, 800, 87, "older")
+
+	e, got := testExport(t, exportState{}, create, older, first)
+	if len(got) != 2 {
+		t.Fatalf("first export got %v, want both messages", got)
+	}
+
+	// Received in the same millisecond as the newest message of the first export, but after it.
+	second := testStatement("INSERT INTO sms VALUES (?,?,?,?,?,?,?)", 3, 2, 2, 1005, 1001, 87, "second")
+	e, got = testExport(t, e.seen, create, older, first, second)
+	if len(got) != 1 || got[0] != "second" {
+		t.Fatalf("second export got %v, want [second]", got)
+	}
+
+	_, got = testExport(t, e.seen, create, older, first, second)
+	if len(got) != 0 {
+		t.Fatalf("third export got %v, want nothing", got)
+	}
+}
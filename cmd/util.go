@@ -2,15 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"syscall"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
+	"github.com/xeals/signal-back/signal"
 	"github.com/xeals/signal-back/types"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 // AppHelp is the help template.
@@ -25,7 +27,7 @@ Commands:
 
 // TODO: Work out how to display global flags here
 // SubcommandHelp is the subcommand help template.
-const SubcommandHelp = `Usage: {{.HelpName}} [OPTION...] BACKUPFILE
+const SubcommandHelp = `Usage: {{.HelpName}} [OPTION...] {{if .ArgsUsage}}{{.ArgsUsage}}{{else}}BACKUPFILE{{end}}
 
 {{if .UsageText}}{{.UsageText}}
 {{else}}{{.Usage}}
@@ -34,72 +36,202 @@ const SubcommandHelp = `Usage: {{.HelpName}} [OPTION...] BACKUPFILE
   {{end}}{{end}}
 `
 
-var coreFlags = []cli.Flag{
-	cli.StringFlag{
-		Name:  "password, p",
-		Usage: "use `PASS` as password for backup file",
-	},
-	cli.StringFlag{
-		Name:  "pwdfile, P",
-		Usage: "read password from `FILE`",
-	},
+var coreFlags = append(passwordFlags,
+	keyfileFlag,
+	memberFlag,
 	cli.BoolFlag{
 		Name:  "verbose, v",
 		Usage: "enable verbose logging output",
 	},
+)
+
+var memberFlag = cli.StringFlag{
+	Name:  "member",
+	Usage: "read the backup from `FILE` inside a zip or tar archive",
 }
 
 func setup(c *cli.Context) (*types.BackupFile, error) {
+	bf, _, err := setupWithPassword(c)
+	return bf, err
+}
+
+// setupWithPassword is setup for commands that also need the password the backup was opened with.
+// If the backup was opened with --keyfile, the password is empty.
+func setupWithPassword(c *cli.Context) (*types.BackupFile, string, error) {
 	// -- Enable logging
 
+	setupLogging(c)
+
+	// -- Verify
+
+	path := c.Args().Get(0)
+	if path == "" {
+		return nil, "", errors.New("must specify a Signal backup file")
+	}
+
+	// -- Initialise
+
+	if file := c.String("keyfile"); file != "" {
+		key, err := readKeyfile(file)
+		if err != nil {
+			return nil, "", err
+		}
+		bf, err := openBackupWithKey(c, path, key)
+		if errors.Is(err, types.ErrKeyMismatch) {
+			return nil, "", errors.Wrapf(err, "can't use %s", file)
+		} else if err != nil {
+			return nil, "", errors.Wrap(err, "failed to open backup file")
+		}
+		return bf, "", nil
+	}
+
+	src, err := passwordSourceFor(c)
+	if err != nil {
+		return nil, "", err
+	}
+	// The password prompt reads from stdin, so it can't be used when the backup does too.
+	if path == "-" && readsStdin(src) {
+		return nil, "", errors.New("must give the password another way when reading the backup from stdin")
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry := src.prompts() && attempt < maxPasswordAttempts
+
+		pass, err := readPasswordSource(src)
+		if errors.Is(err, types.ErrInvalidPassphrase) && retry {
+			fmt.Fprintf(os.Stderr, "Invalid passphrase (%s), please try again.\n", err)
+			continue
+		}
+		if err != nil {
+			return nil, "", errors.Wrap(err, "unable to read password")
+		}
+
+		bf, err := openBackup(c, path, pass)
+		if errors.Is(err, types.ErrWrongPassword) && retry {
+			fmt.Fprintln(os.Stderr, "Incorrect passphrase, please try again.")
+			continue
+		}
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to open backup file")
+		}
+
+		return bf, pass, nil
+	}
+}
+
+// setupLogging enables logging if the verbose flag is set.
+func setupLogging(c *cli.Context) {
 	if c.Bool("verbose") {
 		log.SetOutput(os.Stderr)
 	} else {
 		log.SetOutput(ioutil.Discard)
 	}
+}
 
-	// -- Verify
+// openBackup opens the backup at path, which may be "-" for stdin or an archive containing the
+// backup.
+func openBackup(c *cli.Context, path, pass string) (*types.BackupFile, error) {
+	return openBackupWith(c, path, func(r io.Reader, size int64) (*types.BackupFile, error) {
+		return types.NewBackupFileReader(r, size, pass)
+	})
+}
 
-	if c.Args().Get(0) == "" {
-		return nil, errors.New("must specify a Signal backup file")
+// openBackupWithKey is openBackup using a saved key instead of the password.
+func openBackupWithKey(c *cli.Context, path string, key *types.BackupKey) (*types.BackupFile, error) {
+	return openBackupWith(c, path, func(r io.Reader, size int64) (*types.BackupFile, error) {
+		return types.NewBackupFileReaderWithKey(r, size, key)
+	})
+}
+
+func openBackupWith(c *cli.Context, path string, open func(io.Reader, int64) (*types.BackupFile, error)) (*types.BackupFile, error) {
+	r, size, err := openInput(path, c.String("member"))
+	if err != nil {
+		return nil, err
+	}
+	bf, err := open(r, size)
+	if err != nil {
+		r.Close()
+		return nil, err
 	}
+	return bf, nil
+}
 
-	// -- Initialise
+// writeBackup writes a new backup encrypted with pass to the file at path, using fn to write its
+// frames. fn returns how many frames it wrote, and the new backup is read back to check that it has
+// the same number before it's moved into place.
+func writeBackup(path, pass string, version uint32, fn func(*types.BackupWriter) (int, error)) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".signal-back-")
+	if err != nil {
+		return errors.Wrap(err, "unable to create output file")
+	}
+	defer os.Remove(tmp.Name())
 
-	pass, err := readPassword(c)
+	bw, err := types.NewBackupWriter(tmp, pass, nil, nil, version)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to read password")
+		tmp.Close()
+		return err
 	}
 
-	bf, err := types.NewBackupFile(c.Args().Get(0), pass)
+	frames, err := fn(bw)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to open backup file")
+		return err
 	}
 
-	return bf, nil
+	log.Println("verifying new backup")
+	written, err := types.NewBackupFile(tmp.Name(), pass)
+	if err != nil {
+		return errors.Wrap(err, "unable to read new backup")
+	}
+	count, err := copyFrames(written, nil)
+	written.Close()
+	if err != nil {
+		return errors.Wrap(err, "unable to read new backup")
+	}
+	if count != frames {
+		return errors.Errorf("new backup has %d frames, expected %d", count, frames)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "unable to move new backup into place")
+	}
+	return nil
+}
+
+// isInsert reports whether a statement inserts a row.
+func isInsert(stmt *signal.SqlStatement) bool {
+	return strings.HasPrefix(strings.ToUpper(stmt.GetStatement()), "INSERT INTO ")
 }
 
-func readPassword(c *cli.Context) (string, error) {
-	var pass string
+// Exit codes returned by the CLI, so that scripts can tell failures apart.
+const (
+	ExitError              = 1
+	ExitWrongPassword      = 3
+	ExitBadMAC             = 4
+	ExitTruncated          = 5
+	ExitUnsupportedVersion = 6
+)
 
-	if c.String("password") != "" {
-		pass = c.String("password")
-	} else if c.String("pwdfile") != "" {
-		bs, err := ioutil.ReadFile(c.String("pwdfile"))
-		if err != nil {
-			return "", errors.Wrap(err, "unable to read file")
-		}
-		pass = string(bs)
-	} else {
-		// Read from stdin
-		fmt.Fprint(os.Stderr, "Password: ")
-		raw, err := terminal.ReadPassword(int(syscall.Stdin))
-		if err != nil {
-			return "", errors.Wrap(err, "unable to read from stdin")
-		}
-		pass = string(raw)
+// ExitCode returns the exit code for an error returned by a command.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, types.ErrWrongPassword), errors.Is(err, types.ErrInvalidPassphrase):
+		return ExitWrongPassword
+	case errors.Is(err, types.ErrBadMAC):
+		return ExitBadMAC
+	case errors.Is(err, types.ErrTruncated):
+		return ExitTruncated
+	case errors.Is(err, types.ErrUnsupportedVersion):
+		return ExitUnsupportedVersion
+	}
+	if coder, ok := err.(cli.ExitCoder); ok {
+		return coder.ExitCode()
 	}
-	return pass, nil
+	return ExitError
 }
 
 // E is a wrapper to simply create a cli.ExitError.
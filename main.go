@@ -24,6 +24,13 @@ func main() {
 		cmd.Analyse,
 		cmd.Extract,
 		cmd.Check,
+		cmd.Rekey,
+		cmd.Prune,
+		cmd.Merge,
+		cmd.Diff,
+		cmd.Archive,
+		cmd.Index,
+		cmd.DeriveKey,
 	}
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -52,6 +59,6 @@ func main() {
 	if err := app.Run(os.Args); err != nil {
 		// log.Fatalln(err)
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
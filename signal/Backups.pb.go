@@ -11,9 +11,11 @@ It has these top-level messages:
 	SqlStatement
 	SharedPreference
 	Attachment
+	Sticker
 	Avatar
 	DatabaseVersion
 	Header
+	KeyValue
 	BackupFrame
 */
 package signal
@@ -173,6 +175,31 @@ func (m *Attachment) GetLength() uint32 {
 	return 0
 }
 
+type Sticker struct {
+	RowId            *uint64 `protobuf:"varint,1,opt,name=rowId" json:"rowId,omitempty"`
+	Length           *uint32 `protobuf:"varint,2,opt,name=length" json:"length,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Sticker) Reset()                    { *m = Sticker{} }
+func (m *Sticker) String() string            { return proto.CompactTextString(m) }
+func (*Sticker) ProtoMessage()               {}
+func (*Sticker) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *Sticker) GetRowId() uint64 {
+	if m != nil && m.RowId != nil {
+		return *m.RowId
+	}
+	return 0
+}
+
+func (m *Sticker) GetLength() uint32 {
+	if m != nil && m.Length != nil {
+		return *m.Length
+	}
+	return 0
+}
+
 type Avatar struct {
 	Name             *string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
 	Length           *uint32 `protobuf:"varint,2,opt,name=length" json:"length,omitempty"`
@@ -182,7 +209,7 @@ type Avatar struct {
 func (m *Avatar) Reset()                    { *m = Avatar{} }
 func (m *Avatar) String() string            { return proto.CompactTextString(m) }
 func (*Avatar) ProtoMessage()               {}
-func (*Avatar) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*Avatar) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 func (m *Avatar) GetName() string {
 	if m != nil && m.Name != nil {
@@ -206,7 +233,7 @@ type DatabaseVersion struct {
 func (m *DatabaseVersion) Reset()                    { *m = DatabaseVersion{} }
 func (m *DatabaseVersion) String() string            { return proto.CompactTextString(m) }
 func (*DatabaseVersion) ProtoMessage()               {}
-func (*DatabaseVersion) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*DatabaseVersion) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
 func (m *DatabaseVersion) GetVersion() uint32 {
 	if m != nil && m.Version != nil {
@@ -216,15 +243,16 @@ func (m *DatabaseVersion) GetVersion() uint32 {
 }
 
 type Header struct {
-	Iv               []byte `protobuf:"bytes,1,opt,name=iv" json:"iv,omitempty"`
-	Salt             []byte `protobuf:"bytes,2,opt,name=salt" json:"salt,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	Iv               []byte  `protobuf:"bytes,1,opt,name=iv" json:"iv,omitempty"`
+	Salt             []byte  `protobuf:"bytes,2,opt,name=salt" json:"salt,omitempty"`
+	Version          *uint32 `protobuf:"varint,3,opt,name=version" json:"version,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *Header) Reset()                    { *m = Header{} }
 func (m *Header) String() string            { return proto.CompactTextString(m) }
 func (*Header) ProtoMessage()               {}
-func (*Header) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (*Header) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 func (m *Header) GetIv() []byte {
 	if m != nil {
@@ -240,6 +268,78 @@ func (m *Header) GetSalt() []byte {
 	return nil
 }
 
+func (m *Header) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+type KeyValue struct {
+	Key              *string  `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	BlobValue        []byte   `protobuf:"bytes,2,opt,name=blobValue" json:"blobValue,omitempty"`
+	BooleanValue     *bool    `protobuf:"varint,3,opt,name=booleanValue" json:"booleanValue,omitempty"`
+	FloatValue       *float32 `protobuf:"fixed32,4,opt,name=floatValue" json:"floatValue,omitempty"`
+	IntegerValue     *int32   `protobuf:"varint,5,opt,name=integerValue" json:"integerValue,omitempty"`
+	LongValue        *int64   `protobuf:"varint,6,opt,name=longValue" json:"longValue,omitempty"`
+	StringValue      *string  `protobuf:"bytes,7,opt,name=stringValue" json:"stringValue,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *KeyValue) Reset()                    { *m = KeyValue{} }
+func (m *KeyValue) String() string            { return proto.CompactTextString(m) }
+func (*KeyValue) ProtoMessage()               {}
+func (*KeyValue) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *KeyValue) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *KeyValue) GetBlobValue() []byte {
+	if m != nil {
+		return m.BlobValue
+	}
+	return nil
+}
+
+func (m *KeyValue) GetBooleanValue() bool {
+	if m != nil && m.BooleanValue != nil {
+		return *m.BooleanValue
+	}
+	return false
+}
+
+func (m *KeyValue) GetFloatValue() float32 {
+	if m != nil && m.FloatValue != nil {
+		return *m.FloatValue
+	}
+	return 0
+}
+
+func (m *KeyValue) GetIntegerValue() int32 {
+	if m != nil && m.IntegerValue != nil {
+		return *m.IntegerValue
+	}
+	return 0
+}
+
+func (m *KeyValue) GetLongValue() int64 {
+	if m != nil && m.LongValue != nil {
+		return *m.LongValue
+	}
+	return 0
+}
+
+func (m *KeyValue) GetStringValue() string {
+	if m != nil && m.StringValue != nil {
+		return *m.StringValue
+	}
+	return ""
+}
+
 type BackupFrame struct {
 	Header           *Header           `protobuf:"bytes,1,opt,name=header" json:"header,omitempty"`
 	Statement        *SqlStatement     `protobuf:"bytes,2,opt,name=statement" json:"statement,omitempty"`
@@ -248,13 +348,15 @@ type BackupFrame struct {
 	Version          *DatabaseVersion  `protobuf:"bytes,5,opt,name=version" json:"version,omitempty"`
 	End              *bool             `protobuf:"varint,6,opt,name=end" json:"end,omitempty"`
 	Avatar           *Avatar           `protobuf:"bytes,7,opt,name=avatar" json:"avatar,omitempty"`
+	Sticker          *Sticker          `protobuf:"bytes,8,opt,name=sticker" json:"sticker,omitempty"`
+	KeyValue         *KeyValue         `protobuf:"bytes,9,opt,name=keyValue" json:"keyValue,omitempty"`
 	XXX_unrecognized []byte            `json:"-"`
 }
 
 func (m *BackupFrame) Reset()                    { *m = BackupFrame{} }
 func (m *BackupFrame) String() string            { return proto.CompactTextString(m) }
 func (*BackupFrame) ProtoMessage()               {}
-func (*BackupFrame) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*BackupFrame) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
 func (m *BackupFrame) GetHeader() *Header {
 	if m != nil {
@@ -305,53 +407,77 @@ func (m *BackupFrame) GetAvatar() *Avatar {
 	return nil
 }
 
+func (m *BackupFrame) GetSticker() *Sticker {
+	if m != nil {
+		return m.Sticker
+	}
+	return nil
+}
+
+func (m *BackupFrame) GetKeyValue() *KeyValue {
+	if m != nil {
+		return m.KeyValue
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*SqlStatement)(nil), "signal.SqlStatement")
 	proto.RegisterType((*SqlStatement_SqlParameter)(nil), "signal.SqlStatement.SqlParameter")
 	proto.RegisterType((*SharedPreference)(nil), "signal.SharedPreference")
 	proto.RegisterType((*Attachment)(nil), "signal.Attachment")
+	proto.RegisterType((*Sticker)(nil), "signal.Sticker")
 	proto.RegisterType((*Avatar)(nil), "signal.Avatar")
 	proto.RegisterType((*DatabaseVersion)(nil), "signal.DatabaseVersion")
 	proto.RegisterType((*Header)(nil), "signal.Header")
+	proto.RegisterType((*KeyValue)(nil), "signal.KeyValue")
 	proto.RegisterType((*BackupFrame)(nil), "signal.BackupFrame")
 }
 
 func init() { proto.RegisterFile("signal/Backups.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 531 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x54, 0x5d, 0x8b, 0xd3, 0x40,
-	0x14, 0x25, 0x69, 0x9b, 0xb5, 0xb7, 0x69, 0xb7, 0x0c, 0x45, 0x83, 0xf8, 0x90, 0x0d, 0xb2, 0x04,
-	0x95, 0xc8, 0x06, 0x1f, 0x7c, 0x6d, 0x11, 0x71, 0x5f, 0xa4, 0x4c, 0xc1, 0x47, 0x61, 0xda, 0xdc,
-	0x4d, 0xc2, 0xa6, 0x49, 0x9d, 0x99, 0x54, 0xfc, 0x7f, 0x3e, 0xf9, 0x0b, 0xfc, 0x39, 0x32, 0x93,
-	0xef, 0xba, 0x6f, 0xf7, 0x9e, 0x39, 0xe7, 0xce, 0xe4, 0xdc, 0x43, 0x60, 0x25, 0xd2, 0x38, 0x67,
-	0xd9, 0xfb, 0x0d, 0x3b, 0x3c, 0x96, 0x27, 0x11, 0x9c, 0x78, 0x21, 0x0b, 0x62, 0x55, 0xa8, 0xf7,
-	0xc7, 0x04, 0x7b, 0xf7, 0x23, 0xdb, 0x49, 0x26, 0xf1, 0x88, 0xb9, 0x24, 0xaf, 0x60, 0x2a, 0x9a,
-	0xc6, 0x31, 0x5c, 0xc3, 0x9f, 0xd2, 0x0e, 0x20, 0x6b, 0x80, 0x13, 0xe3, 0xec, 0x88, 0x12, 0xb9,
-	0x70, 0x4c, 0x77, 0xe4, 0xcf, 0xc2, 0x9b, 0xa0, 0x9a, 0x15, 0xf4, 0xe7, 0xa8, 0x66, 0xdb, 0x30,
-	0x69, 0x4f, 0xf4, 0xf2, 0xaf, 0xa1, 0x6f, 0x6c, 0x0f, 0xc9, 0x2d, 0x2c, 0x84, 0xe4, 0x69, 0x1e,
-	0x6b, 0x48, 0x22, 0xaf, 0xaf, 0xbd, 0x40, 0xc9, 0x1b, 0x58, 0xa6, 0xb9, 0xc4, 0x18, 0x79, 0xab,
-	0x75, 0x4c, 0xd7, 0xf0, 0xc7, 0xf4, 0x3f, 0x9c, 0xf8, 0x70, 0x1d, 0x15, 0xe5, 0x3e, 0xc3, 0x8e,
-	0x3a, 0x72, 0x0d, 0xdf, 0xa0, 0x97, 0x30, 0x79, 0x0d, 0xf3, 0x7d, 0x56, 0xec, 0x3b, 0xde, 0xd8,
-	0x35, 0x7c, 0x9b, 0x0e, 0x41, 0xc5, 0xca, 0xcb, 0x2c, 0x6b, 0x3f, 0xc3, 0x99, 0xb8, 0x86, 0xff,
-	0x8c, 0x0e, 0x41, 0xef, 0x2b, 0x2c, 0x77, 0x09, 0xe3, 0x18, 0x6d, 0x39, 0x3e, 0x20, 0xc7, 0xfc,
-	0x80, 0x84, 0xc0, 0xf8, 0x21, 0xcd, 0xb0, 0xfe, 0x26, 0x5d, 0x93, 0x25, 0x8c, 0x1e, 0xf1, 0x97,
-	0x7e, 0xfc, 0x94, 0xaa, 0x92, 0xac, 0x60, 0x72, 0x66, 0x59, 0x89, 0xfa, 0x95, 0x53, 0x5a, 0x35,
-	0xde, 0x77, 0x80, 0xb5, 0x94, 0xec, 0x90, 0x68, 0xef, 0x57, 0x30, 0xe1, 0xc5, 0xcf, 0xfb, 0x48,
-	0x8f, 0x1a, 0xd3, 0xaa, 0x21, 0x1e, 0xd8, 0xac, 0xe5, 0xdc, 0x47, 0xb5, 0x23, 0x03, 0x8c, 0x3c,
-	0x07, 0x2b, 0xc3, 0x3c, 0x96, 0x89, 0x1e, 0x3f, 0xa7, 0x75, 0xe7, 0x7d, 0x00, 0x6b, 0x7d, 0x66,
-	0x92, 0x71, 0xf5, 0xca, 0x9c, 0x1d, 0xdb, 0x57, 0xaa, 0xba, 0xa7, 0x32, 0x07, 0xaa, 0xb7, 0x70,
-	0xfd, 0x89, 0x49, 0xb6, 0x67, 0x02, 0xbf, 0x21, 0x17, 0x69, 0x91, 0x13, 0x07, 0xae, 0xce, 0x55,
-	0xa9, 0x27, 0xcc, 0x69, 0xd3, 0x7a, 0xef, 0xc0, 0xfa, 0x82, 0x2c, 0x42, 0x4e, 0x16, 0x60, 0xa6,
-	0x67, 0x7d, 0x6c, 0x53, 0x33, 0x3d, 0xab, 0x2b, 0x05, 0xcb, 0xa4, 0x1e, 0x6e, 0x53, 0x5d, 0x7b,
-	0xbf, 0x4d, 0x98, 0x55, 0x39, 0xfd, 0xac, 0x3c, 0x25, 0xb7, 0x60, 0x25, 0x5a, 0xad, 0x75, 0xb3,
-	0x70, 0xd1, 0x44, 0xad, 0x9a, 0x49, 0xeb, 0x53, 0x12, 0xf6, 0x43, 0x6b, 0x6a, 0xea, 0xea, 0xa9,
-	0x54, 0xf6, 0xa3, 0xfc, 0x11, 0xe0, 0xd4, 0xae, 0x49, 0x1b, 0x33, 0x0b, 0x9d, 0x56, 0x74, 0xb1,
-	0x46, 0xda, 0xe3, 0x92, 0x10, 0xa0, 0xb3, 0x57, 0xe7, 0x65, 0x16, 0x92, 0x46, 0xd9, 0x2d, 0x8c,
-	0xf6, 0x58, 0xe4, 0xae, 0x73, 0x68, 0xa2, 0x05, 0x2f, 0x1a, 0xc1, 0x85, 0x97, 0xad, 0x75, 0x2a,
-	0x25, 0x98, 0x47, 0x8e, 0xa5, 0x93, 0xa6, 0x4a, 0x65, 0x07, 0xd3, 0xfb, 0x72, 0xae, 0x86, 0x76,
-	0x54, 0x5b, 0xa4, 0xf5, 0xe9, 0xe6, 0x0e, 0x6e, 0x0a, 0x1e, 0x07, 0x32, 0x29, 0xca, 0x38, 0x91,
-	0x07, 0x9e, 0x1e, 0x31, 0x10, 0x78, 0x28, 0x39, 0x8a, 0xa3, 0x08, 0xf6, 0xda, 0xe0, 0x8d, 0x5d,
-	0x19, 0xbd, 0x55, 0xbf, 0x03, 0xf1, 0x2f, 0x00, 0x00, 0xff, 0xff, 0x19, 0x28, 0x16, 0x8b, 0x26,
-	0x04, 0x00, 0x00,
+	// 669 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x54, 0xdd, 0x6a, 0xdb, 0x4c,
+	0x10, 0x45, 0xf2, 0xff, 0x58, 0x49, 0xcc, 0x62, 0xbe, 0x4f, 0x94, 0x50, 0x14, 0x51, 0x82, 0xfa,
+	0x83, 0x4b, 0x4c, 0xa1, 0xbd, 0x4d, 0x28, 0xa1, 0xa1, 0x50, 0xc2, 0x1a, 0x72, 0x59, 0x58, 0xdb,
+	0x63, 0x59, 0x78, 0x2d, 0xb9, 0xbb, 0x6b, 0x97, 0x3c, 0x4c, 0x5f, 0xa8, 0x4f, 0xd0, 0xeb, 0x3e,
+	0x49, 0xd9, 0x5d, 0xfd, 0xba, 0xcd, 0xdd, 0xee, 0x99, 0x33, 0xa3, 0x9d, 0x33, 0x67, 0x04, 0x63,
+	0x99, 0xc4, 0x29, 0xe3, 0x6f, 0x6f, 0xd8, 0x62, 0xb3, 0xdf, 0xc9, 0xc9, 0x4e, 0x64, 0x2a, 0x23,
+	0x5d, 0x8b, 0x86, 0x3f, 0x5d, 0xf0, 0x66, 0xdf, 0xf8, 0x4c, 0x31, 0x85, 0x5b, 0x4c, 0x15, 0x39,
+	0x87, 0x81, 0x2c, 0x2e, 0xbe, 0x13, 0x38, 0xd1, 0x80, 0x56, 0x00, 0xb9, 0x06, 0xd8, 0x31, 0xc1,
+	0xb6, 0xa8, 0x50, 0x48, 0xdf, 0x0d, 0x5a, 0xd1, 0x70, 0x7a, 0x31, 0xb1, 0xb5, 0x26, 0xf5, 0x3a,
+	0xfa, 0x72, 0x5f, 0x30, 0x69, 0x2d, 0xe9, 0xd9, 0x2f, 0x07, 0xbc, 0x7a, 0x90, 0x5c, 0xc2, 0xa9,
+	0x54, 0x22, 0x49, 0x63, 0x03, 0x29, 0x14, 0xf9, 0x67, 0x8f, 0x50, 0xf2, 0x0a, 0x46, 0x49, 0xaa,
+	0x30, 0x46, 0x51, 0xe6, 0xfa, 0x6e, 0xe0, 0x44, 0x6d, 0xfa, 0x17, 0x4e, 0x22, 0x38, 0x5b, 0x66,
+	0xfb, 0x39, 0xc7, 0x8a, 0xda, 0x0a, 0x9c, 0xc8, 0xa1, 0xc7, 0x30, 0x79, 0x01, 0x27, 0x73, 0x9e,
+	0xcd, 0x2b, 0x5e, 0x3b, 0x70, 0x22, 0x8f, 0x36, 0x41, 0xcd, 0x4a, 0xf7, 0x9c, 0x97, 0x6d, 0xf8,
+	0x9d, 0xc0, 0x89, 0xfa, 0xb4, 0x09, 0x86, 0x5f, 0x60, 0x34, 0x5b, 0x33, 0x81, 0xcb, 0x7b, 0x81,
+	0x2b, 0x14, 0x98, 0x2e, 0x90, 0x10, 0x68, 0xaf, 0x12, 0x8e, 0x79, 0x4f, 0xe6, 0x4c, 0x46, 0xd0,
+	0xda, 0xe0, 0xa3, 0x79, 0xfc, 0x80, 0xea, 0x23, 0x19, 0x43, 0xe7, 0xc0, 0xf8, 0x1e, 0xcd, 0x2b,
+	0x07, 0xd4, 0x5e, 0xc2, 0xaf, 0x00, 0xd7, 0x4a, 0xb1, 0xc5, 0xda, 0x68, 0x3f, 0x86, 0x8e, 0xc8,
+	0xbe, 0xdf, 0x2d, 0x4d, 0xa9, 0x36, 0xb5, 0x17, 0x12, 0x82, 0xc7, 0x4a, 0xce, 0xdd, 0x32, 0x57,
+	0xa4, 0x81, 0x91, 0xff, 0xa0, 0xcb, 0x31, 0x8d, 0xd5, 0xda, 0x94, 0x3f, 0xa1, 0xf9, 0x2d, 0x7c,
+	0x0f, 0xbd, 0x99, 0x4a, 0x16, 0x1b, 0x14, 0x4f, 0x14, 0xaf, 0x12, 0xdd, 0x46, 0xe2, 0x3b, 0xe8,
+	0x5e, 0x1f, 0x98, 0x62, 0x42, 0xb7, 0x97, 0xb2, 0x6d, 0xd9, 0x9e, 0x3e, 0x3f, 0x99, 0xf5, 0x1a,
+	0xce, 0x3e, 0x32, 0xc5, 0xe6, 0x4c, 0xe2, 0x03, 0x0a, 0x99, 0x64, 0x29, 0xf1, 0xa1, 0x77, 0xb0,
+	0x47, 0x53, 0xe1, 0x84, 0x16, 0xd7, 0xf0, 0x16, 0xba, 0x9f, 0x90, 0x2d, 0x51, 0x90, 0x53, 0x70,
+	0x93, 0x83, 0x09, 0x7b, 0xd4, 0x4d, 0x0e, 0xfa, 0x93, 0x92, 0x71, 0x65, 0x8a, 0x7b, 0xd4, 0x9c,
+	0xeb, 0x75, 0x5a, 0xcd, 0x3a, 0xbf, 0x1d, 0xe8, 0x7f, 0xc6, 0xc7, 0x07, 0x2d, 0x68, 0x21, 0xbc,
+	0x53, 0x09, 0x7f, 0x0e, 0x03, 0x3d, 0x69, 0x13, 0xce, 0x2b, 0x56, 0x80, 0x16, 0x77, 0x9e, 0x65,
+	0x1c, 0x59, 0xfa, 0x50, 0x4e, 0xa7, 0x4f, 0x1b, 0x18, 0x79, 0x0e, 0xb0, 0xe2, 0x19, 0x53, 0x96,
+	0xa1, 0xdd, 0xe3, 0xd2, 0x1a, 0xa2, 0x6b, 0xe4, 0xf6, 0xb4, 0x0c, 0xed, 0x9c, 0x0e, 0x6d, 0x60,
+	0xfa, 0x15, 0x3c, 0x4b, 0x63, 0x4b, 0xe8, 0x06, 0x4e, 0xd4, 0xa2, 0x15, 0x40, 0x02, 0x18, 0xda,
+	0x55, 0xb0, 0xf1, 0x9e, 0x79, 0x7d, 0x1d, 0x0a, 0x7f, 0xb4, 0x60, 0x68, 0xf7, 0xfb, 0x56, 0x7b,
+	0x91, 0x5c, 0x42, 0x77, 0x6d, 0xc4, 0x33, 0xad, 0x0e, 0xa7, 0xa7, 0xc5, 0x8a, 0x5a, 0x49, 0x69,
+	0x1e, 0x25, 0xd3, 0xfa, 0xb2, 0xbb, 0x86, 0x3a, 0xfe, 0xd7, 0x36, 0xd7, 0x7f, 0x01, 0x1f, 0x00,
+	0x76, 0xa5, 0xbd, 0x8d, 0x22, 0xc3, 0xa9, 0x5f, 0x26, 0x1d, 0xd9, 0x9f, 0xd6, 0xb8, 0x64, 0x0a,
+	0x50, 0xd9, 0xd2, 0x28, 0x35, 0x9c, 0x92, 0x22, 0xb3, 0x32, 0x3a, 0xad, 0xb1, 0xc8, 0x55, 0x35,
+	0xd8, 0x8e, 0x49, 0xf8, 0xbf, 0x48, 0x38, 0xb2, 0x52, 0x39, 0x71, 0x3d, 0x64, 0x4c, 0x97, 0x46,
+	0xc6, 0x3e, 0xd5, 0x47, 0x2d, 0x07, 0x33, 0x76, 0xf5, 0x7b, 0x4d, 0x39, 0xac, 0x89, 0x69, 0x1e,
+	0x25, 0x2f, 0xa1, 0x27, 0xed, 0x3e, 0xf8, 0x7d, 0x43, 0x3c, 0x2b, 0xfb, 0xb2, 0x30, 0x2d, 0xe2,
+	0xe4, 0x0d, 0xf4, 0x37, 0xb9, 0xab, 0xfc, 0x81, 0xe1, 0x8e, 0x0a, 0x6e, 0xe1, 0x36, 0x5a, 0x32,
+	0x6e, 0xae, 0xe0, 0x22, 0x13, 0xf1, 0x44, 0xad, 0xb3, 0x7d, 0xbc, 0x56, 0x0b, 0x91, 0x6c, 0x71,
+	0x22, 0x71, 0xb1, 0x17, 0x28, 0xb7, 0x72, 0x32, 0x37, 0x93, 0xbb, 0xf1, 0xec, 0x04, 0xef, 0xf5,
+	0xff, 0x59, 0xfe, 0x19, 0x00, 0x7a, 0x7e, 0xf3, 0x93, 0xb7, 0x05, 0x00, 0x00,
 }
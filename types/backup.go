@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
@@ -26,8 +27,22 @@ import (
 // attachment.
 const ATTACHMENT_BUFFER_SIZE = 8192
 
-// ProtoCommitHash is the commit hash of the Signal Protobuf spec.
-var ProtoCommitHash = "d6610f0"
+// BackupVersionEncryptedLength is the first backup file version whose frame lengths are encrypted
+// along with the frames themselves. Backups without a version in their header are version 0.
+const BackupVersionEncryptedLength = 1
+
+// MaxBackupVersion is the newest backup file version that can be read.
+const MaxBackupVersion = BackupVersionEncryptedLength
+
+// MaxFrameLength is the largest frame that will be read from a backup whose size isn't known. When
+// the size is known, frames can be as large as the backup itself.
+const MaxFrameLength = 64 << 20
+
+// ProtoCommitHash is the commit hash of the Signal Protobuf spec. Like `git describe --dirty`, it
+// is suffixed with "-dirty" while signal/Backups.proto has changes that aren't from that commit:
+// Header.version, Sticker and KeyValue were added by hand, and the hash should be replaced with the
+// Signal commit that has all of them when the spec is next synced.
+var ProtoCommitHash = "d6610f0-dirty"
 
 // BackupFile stores information about a given backup file.
 //
@@ -36,36 +51,108 @@ var ProtoCommitHash = "d6610f0"
 //
 // Closing the underlying file handle is the responsibilty of the programmer if implementing the
 // iteration manually, or is done as part of the Consume method.
+//
+// FileSize is zero if the backup is read from a stream of unknown length.
 type BackupFile struct {
-	file      *os.File
+	file      io.ReadCloser
 	FileSize  int64
 	CipherKey []byte
 	MacKey    []byte
 	Mac       hash.Hash
 	IV        []byte
+	Salt      []byte
 	Counter   uint32
+	Version   uint32
+	Schema    *Schema
+
+	// Filter, if set, is called with every frame read from the backup. Frames it returns false for
+	// are skipped along with any data that follows them, and aren't returned by Frame.
+	Filter func(*signal.BackupFrame) bool
+
+	// OnProgress, if set, is called every ProgressInterval or so while the backup is being read,
+	// and once more when the end of it is reached.
+	OnProgress func(Progress)
+
+	frames      uint64
+	attachments uint64
+	peeked      *signal.BackupFrame
+
+	progressStart time.Time
+	progressLast  time.Time
+
+	// Where the frames start, and how far into the file has been read, for seeking with an index.
+	start        int64
+	startCounter uint32
+	offset       int64
+
+	// A copy of the IV that isn't changed as frames are read, for decrypting out of order.
+	startIV []byte
 }
 
 // NewBackupFile initialises a backup file for reading using the provided path
 // and password.
+//
+// The first frame is read straight away to check the password, so that a wrong password fails
+// with ErrWrongPassword before anything else is done with the backup.
 func NewBackupFile(path, password string) (*BackupFile, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open backup file")
 	}
-	size := info.Size()
 
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open backup file")
 	}
 
+	bf, err := NewBackupFileReader(file, info.Size(), password)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return bf, nil
+}
+
+// NewBackupFileReader initialises a backup file for reading from r, such as a pipe, using the
+// provided password. size is the length of the backup in bytes, or zero if it isn't known.
+//
+// If r is also an io.Closer it is closed along with the backup file. It is not closed if an error
+// is returned.
+func NewBackupFileReader(r io.Reader, size int64, password string) (*BackupFile, error) {
+	return newBackupFileReader(r, size, func(salt []byte) (*BackupKey, error) {
+		return DeriveBackupKey(password, salt), nil
+	})
+}
+
+// NewBackupFileReaderWithKey is like NewBackupFileReader, but uses a key derived earlier instead
+// of the password. If the key was derived for a different backup, ErrKeyMismatch is returned.
+func NewBackupFileReaderWithKey(r io.Reader, size int64, key *BackupKey) (*BackupFile, error) {
+	return newBackupFileReader(r, size, func(salt []byte) (*BackupKey, error) {
+		if !bytes.Equal(salt, key.Salt) {
+			return nil, ErrKeyMismatch
+		}
+		return key, nil
+	})
+}
+
+// newBackupFileReader reads the header of the backup in r, and uses keyFor to get the key for its
+// salt.
+func newBackupFileReader(r io.Reader, size int64, keyFor func(salt []byte) (*BackupKey, error)) (*BackupFile, error) {
+	file, ok := r.(io.ReadCloser)
+	if !ok {
+		file = ioutil.NopCloser(r)
+	}
+
 	headerLengthBytes := make([]byte, 4)
-	_, err = io.ReadFull(file, headerLengthBytes)
+	_, err := io.ReadFull(file, headerLengthBytes)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read headerLengthBytes")
 	}
 	headerLength := bytesToUint32(headerLengthBytes)
+	if headerLength > MaxFrameLength {
+		return nil, errors.Errorf("header length %d is too large; is this a Signal backup?", headerLength)
+	}
 
 	headerFrame := make([]byte, headerLength)
 	_, err = io.ReadFull(file, headerFrame)
@@ -82,43 +169,108 @@ func NewBackupFile(path, password string) (*BackupFile, error) {
 		return nil, errors.New("No IV in header")
 	}
 
-	key := backupKey(password, frame.Header.Salt)
-	derived := deriveSecrets(key, []byte("Backup Export"))
-	cipherKey := derived[:32]
-	macKey := derived[32:]
+	version := frame.Header.GetVersion()
+	if version > MaxBackupVersion {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "version %d is newer than %d", version, MaxBackupVersion)
+	}
 
-	return &BackupFile{
+	key, err := keyFor(frame.Header.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	bf := &BackupFile{
 		file:      file,
 		FileSize:  size,
-		CipherKey: cipherKey,
-		MacKey:    macKey,
-		Mac:       hmac.New(crypto.SHA256.New, macKey),
+		CipherKey: key.CipherKey,
+		MacKey:    key.MacKey,
+		Mac:       hmac.New(crypto.SHA256.New, key.MacKey),
 		IV:        iv,
+		Salt:      frame.Header.Salt,
 		Counter:   bytesToUint32(iv),
-	}, nil
-}
+		Version:   version,
+		Schema:    NewSchema(),
 
-// Frame returns the next frame in the file.
-func (bf *BackupFile) Frame() (*signal.BackupFrame, error) {
-	length := make([]byte, 4)
-	_, err := io.ReadFull(bf.file, length)
-	if err != nil {
+		start:        int64(4 + headerLength),
+		startCounter: bytesToUint32(iv),
+		offset:       int64(4 + headerLength),
+		startIV:      append([]byte(nil), iv...),
+	}
+
+	if err = bf.verify(); err != nil {
 		return nil, err
 	}
 
-	frameLength := bytesToUint32(length)
-	frame := make([]byte, frameLength)
+	return bf, nil
+}
 
-	io.ReadFull(bf.file, frame)
+// verify reads the first frame, which should always be the database version, and keeps it to be
+// returned by the next call to Frame.
+func (bf *BackupFile) verify() error {
+	f, err := bf.readFrame()
+	if err == io.EOF {
+		return errors.Wrap(ErrTruncated, "backup has no frames")
+	} else if err != nil {
+		return err
+	}
+	if f.GetVersion() == nil {
+		return errors.New("backup does not start with a database version")
+	}
+	bf.peeked = f
+	return nil
+}
 
-	theirMac := frame[:len(frame)-10]
+// Frame returns the next frame in the file. Any table definitions in the frame are recorded in
+// the backup's Schema.
+func (bf *BackupFile) Frame() (*signal.BackupFrame, error) {
+	for {
+		f, err := bf.readFrame()
+		if err != nil || bf.Filter == nil || bf.Filter(f) {
+			return f, err
+		}
+		if length := FrameDataLength(f); length > 0 {
+			if err = bf.DecryptAttachment(length, ioutil.Discard); err != nil {
+				return nil, errors.Wrap(err, "failed to skip filtered frame")
+			}
+		}
+	}
+}
 
-	bf.Mac.Reset()
-	bf.Mac.Write(frame)
-	ourMac := bf.Mac.Sum(nil)
+// FramesRead returns how many frames have been read from the backup so far, including any that
+// Filter skipped. The frame last returned by Frame is number FramesRead()-1 in the backup.
+func (bf *BackupFile) FramesRead() uint64 {
+	return bf.frames
+}
+
+// FrameDataLength returns the length of the binary data that follows a frame in the backup, or zero
+// if it has none.
+func FrameDataLength(f *signal.BackupFrame) uint32 {
+	switch {
+	case f.GetAttachment() != nil:
+		return f.GetAttachment().GetLength()
+	case f.GetAvatar() != nil:
+		return f.GetAvatar().GetLength()
+	case f.GetSticker() != nil:
+		return f.GetSticker().GetLength()
+	}
+	return 0
+}
+
+func (bf *BackupFile) readFrame() (*signal.BackupFrame, error) {
+	if f := bf.peeked; f != nil {
+		bf.peeked = nil
+		return f, nil
+	}
 
-	if bytes.Equal(theirMac, ourMac) {
-		return nil, errors.New("Bad MAC")
+	length := make([]byte, 4)
+	_, err := bf.readFull(length)
+	if err == io.ErrUnexpectedEOF {
+		return nil, errors.Wrap(ErrTruncated, "failed to read frame length")
+	} else if err == io.EOF {
+		bf.reportProgress(true)
+		return nil, err
+	} else if err != nil {
+		return nil, err
 	}
 
 	uint32ToBytes(bf.IV, bf.Counter)
@@ -130,84 +282,242 @@ func (bf *BackupFile) Frame() (*signal.BackupFrame, error) {
 	}
 	stream := cipher.NewCTR(aesCipher, bf.IV)
 
-	output := make([]byte, len(frame)-10)
-	stream.XORKeyStream(output, frame[:len(frame)-10])
+	bf.Mac.Reset()
+
+	// Newer backups encrypt the length with the start of the frame's key stream, and include it in
+	// the MAC.
+	if bf.Version >= BackupVersionEncryptedLength {
+		bf.Mac.Write(length)
+		stream.XORKeyStream(length, length)
+	}
+
+	// The length can't be verified until the frame has been read, so catch anything that would
+	// obviously fail rather than trying to read it.
+	frameLength := bytesToUint32(length)
+	if frameLength < 10 || int64(frameLength) > bf.maxFrameLength() {
+		if bf.frames == 0 && bf.Version >= BackupVersionEncryptedLength {
+			return nil, ErrWrongPassword
+		}
+		return nil, errors.Wrapf(ErrBadMAC, "frame %d has invalid length %d", bf.frames, frameLength)
+	}
+	frame := make([]byte, frameLength)
+
+	if _, err = bf.readFull(frame); err != nil {
+		return nil, errors.Wrap(truncated(err), "failed to read frame")
+	}
+
+	body, theirMac := frame[:len(frame)-10], frame[len(frame)-10:]
+
+	bf.Mac.Write(body)
+	ourMac := bf.Mac.Sum(nil)[:10]
+
+	if !hmac.Equal(theirMac, ourMac) {
+		// The first frame can't be corrupt in any interesting way, so failing to verify it means
+		// the keys are wrong.
+		if bf.frames == 0 {
+			return nil, ErrWrongPassword
+		}
+		return nil, errors.Wrapf(ErrBadMAC, "frame %d", bf.frames)
+	}
+	bf.frames++
+
+	output := make([]byte, len(body))
+	stream.XORKeyStream(output, body)
 
 	decoded := new(signal.BackupFrame)
-	proto.Unmarshal(output, decoded)
+	if err = proto.Unmarshal(output, decoded); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode frame %d", bf.frames-1)
+	}
+
+	if FrameDataLength(decoded) > 0 {
+		bf.attachments++
+	}
+
+	if stmt := decoded.GetStatement(); stmt != nil {
+		if err = bf.Schema.Observe(stmt); err != nil {
+			return nil, errors.Wrap(err, "failed to read table schema")
+		}
+	}
 
 	return decoded, nil
 }
 
+// maxFrameLength returns the largest frame length that could be valid for the backup.
+func (bf *BackupFile) maxFrameLength() int64 {
+	if bf.FileSize > 0 {
+		return bf.FileSize
+	}
+	return MaxFrameLength
+}
+
 // DecryptAttachment reads the attachment immediately next in the file's bytes, using a streaming
 // intermediate buffer of size ATTACHMENT_BUFFER_SIZE.
+//
+// The attachment's MAC can only be checked once all of it has been read, so on ErrBadMAC the data
+// already written to out should be discarded.
 func (bf *BackupFile) DecryptAttachment(length uint32, out io.Writer) error {
+	r, err := bf.AttachmentReader(length)
+	if err != nil {
+		return err
+	}
+	return copyAttachment(out, r)
+}
+
+// AttachmentReader returns a reader for the attachment immediately next in the file, which
+// decrypts it as it's read. Once all of it has been read, its MAC is checked, and the reader
+// returns ErrBadMAC instead of io.EOF if it's wrong; anything already read from it should then be
+// discarded.
+//
+// The attachment must be read to the end before the next frame is read.
+func (bf *BackupFile) AttachmentReader(length uint32) (io.Reader, error) {
 	if length == 0 {
-		return errors.New("can't read attachment of length 0")
+		return nil, errors.New("can't read attachment of length 0")
 	}
 
 	uint32ToBytes(bf.IV, bf.Counter)
 	bf.Counter++
 
-	aesCipher, err := aes.NewCipher(bf.CipherKey)
+	r, err := newAttachmentReader(bf.readFull, bf.CipherKey, bf.Mac, bf.IV, length)
 	if err != nil {
-		return errors.New("Bad cipher")
+		return nil, err
 	}
-	stream := cipher.NewCTR(aesCipher, bf.IV)
-	bf.Mac.Write(bf.IV)
+	return r, nil
+}
 
-	buf := make([]byte, ATTACHMENT_BUFFER_SIZE)
-	output := make([]byte, len(buf))
+// attachmentReader decrypts attachment data as it's read, and checks the MAC that follows it.
+type attachmentReader struct {
+	readFull  func([]byte) (int, error)
+	stream    cipher.Stream
+	mac       hash.Hash
+	remaining uint32
+	err       error
+}
 
-	for length > 0 {
-		// Go can't read an arbitrary number of bytes,
-		// so we have to downsize the containing buffer instead.
-		if length < ATTACHMENT_BUFFER_SIZE {
-			buf = make([]byte, length)
-		}
-		n, err := bf.file.Read(buf)
-		if err != nil {
-			return errors.Wrap(err, "failed to read att")
-		}
-		bf.Mac.Write(buf)
+// newAttachmentReader returns a reader for length bytes of attachment data, using readFull to read
+// from the file.
+func newAttachmentReader(readFull func([]byte) (int, error), cipherKey []byte, mac hash.Hash, iv []byte, length uint32) (*attachmentReader, error) {
+	aesCipher, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, errors.New("Bad cipher")
+	}
+	mac.Reset()
+	mac.Write(iv)
+
+	return &attachmentReader{
+		readFull:  readFull,
+		stream:    cipher.NewCTR(aesCipher, iv),
+		mac:       mac,
+		remaining: length,
+	}, nil
+}
 
-		stream.XORKeyStream(output, buf)
-		if _, err = out.Write(output); err != nil {
-			return errors.Wrap(err, "can't write to output")
-		}
+func (r *attachmentReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
 
-		length -= uint32(n)
+	n := len(p)
+	if uint32(n) > r.remaining {
+		n = int(r.remaining)
+	}
+	if _, err := r.readFull(p[:n]); err != nil {
+		r.err = errors.Wrap(truncated(err), "failed to read att")
+		return 0, r.err
+	}
+	r.mac.Write(p[:n])
+	r.stream.XORKeyStream(p[:n], p[:n])
+	r.remaining -= uint32(n)
+
+	// Check the MAC straight away, so that the file has been read past it even if the caller stops
+	// once it has all the data.
+	if r.remaining == 0 {
+		r.err = r.verify()
 	}
+	return n, nil
+}
 
+func (r *attachmentReader) verify() error {
 	theirMac := make([]byte, 10)
-	io.ReadFull(bf.file, theirMac)
-	ourMac := bf.Mac.Sum(nil)
+	if _, err := r.readFull(theirMac); err != nil {
+		return errors.Wrap(truncated(err), "failed to read attachment MAC")
+	}
+	ourMac := r.mac.Sum(nil)[:10]
 
-	if bytes.Equal(theirMac, ourMac) {
-		return errors.New("Bad MAC")
+	if !hmac.Equal(theirMac, ourMac) {
+		return errors.Wrap(ErrBadMAC, "attachment")
 	}
+	return io.EOF
+}
 
-	return nil
+// copyAttachment writes everything from an attachment reader to out.
+func copyAttachment(out io.Writer, r io.Reader) error {
+	buf := make([]byte, ATTACHMENT_BUFFER_SIZE)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				return errors.Wrap(werr, "can't write to output")
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// readFull reads exactly len(p) bytes from the file, keeping track of the offset.
+func (bf *BackupFile) readFull(p []byte) (int, error) {
+	n, err := io.ReadFull(bf.file, p)
+	bf.offset += int64(n)
+	bf.reportProgress(false)
+	return n, err
 }
 
 // ConsumeFuncs stores parameters for a Consume operation.
 type ConsumeFuncs struct {
+	VersionFunc    func(*signal.DatabaseVersion) error
+	EndFunc        func() error
 	AttachmentFunc func(*signal.Attachment) error
 	AvatarFunc     func(*signal.Avatar) error
+	StickerFunc    func(*signal.Sticker) error
 	StatementFunc  func(*signal.SqlStatement) error
+	PreferenceFunc func(*signal.SharedPreference) error
+	KeyValueFunc   func(*signal.KeyValue) error
 }
 
+// DiscardConsumeFuncs returns a set of functions that do nothing with each frame, except to skip
+// over any binary data that follows it.
 func DiscardConsumeFuncs(bf *BackupFile) ConsumeFuncs {
 	return ConsumeFuncs{
+		VersionFunc: func(v *signal.DatabaseVersion) error {
+			return nil
+		},
+		EndFunc: func() error {
+			return nil
+		},
 		AttachmentFunc: func(a *signal.Attachment) error {
 			return bf.DecryptAttachment(a.GetLength(), ioutil.Discard)
 		},
 		AvatarFunc: func(a *signal.Avatar) error {
 			return bf.DecryptAttachment(a.GetLength(), ioutil.Discard)
 		},
+		StickerFunc: func(s *signal.Sticker) error {
+			return bf.DecryptAttachment(s.GetLength(), ioutil.Discard)
+		},
 		StatementFunc: func(s *signal.SqlStatement) error {
 			return nil
 		},
+		PreferenceFunc: func(p *signal.SharedPreference) error {
+			return nil
+		},
+		KeyValueFunc: func(kv *signal.KeyValue) error {
+			return nil
+		},
 	}
 }
 
@@ -228,15 +538,30 @@ func (bf *BackupFile) Consume(fns ConsumeFuncs) error {
 
 	defer bf.Close()
 
+	if fns.VersionFunc == nil {
+		fns.VersionFunc = discard.VersionFunc
+	}
+	if fns.EndFunc == nil {
+		fns.EndFunc = discard.EndFunc
+	}
 	if fns.AttachmentFunc == nil {
 		fns.AttachmentFunc = discard.AttachmentFunc
 	}
 	if fns.AvatarFunc == nil {
 		fns.AvatarFunc = discard.AvatarFunc
 	}
+	if fns.StickerFunc == nil {
+		fns.StickerFunc = discard.StickerFunc
+	}
 	if fns.StatementFunc == nil {
 		fns.StatementFunc = discard.StatementFunc
 	}
+	if fns.PreferenceFunc == nil {
+		fns.PreferenceFunc = discard.PreferenceFunc
+	}
+	if fns.KeyValueFunc == nil {
+		fns.KeyValueFunc = discard.KeyValueFunc
+	}
 
 	for {
 		f, err = bf.Frame()
@@ -246,6 +571,11 @@ func (bf *BackupFile) Consume(fns ConsumeFuncs) error {
 			return err
 		}
 
+		if v := f.GetVersion(); v != nil {
+			if err = fns.VersionFunc(v); err != nil {
+				return errors.Wrap(err, "consume [version]")
+			}
+		}
 		if a := f.GetAttachment(); a != nil {
 			if err = fns.AttachmentFunc(a); err != nil {
 				return errors.Wrap(err, "consume [attachment]")
@@ -256,11 +586,31 @@ func (bf *BackupFile) Consume(fns ConsumeFuncs) error {
 				return errors.Wrap(err, "consume [avatar]")
 			}
 		}
+		if s := f.GetSticker(); s != nil {
+			if err = fns.StickerFunc(s); err != nil {
+				return errors.Wrap(err, "consume [sticker]")
+			}
+		}
 		if stmt := f.GetStatement(); stmt != nil {
 			if err = fns.StatementFunc(stmt); err != nil {
 				return errors.Wrap(err, "consume [statement]")
 			}
 		}
+		if p := f.GetPreference(); p != nil {
+			if err = fns.PreferenceFunc(p); err != nil {
+				return errors.Wrap(err, "consume [preference]")
+			}
+		}
+		if kv := f.GetKeyValue(); kv != nil {
+			if err = fns.KeyValueFunc(kv); err != nil {
+				return errors.Wrap(err, "consume [key value]")
+			}
+		}
+		if f.GetEnd() {
+			if err = fns.EndFunc(); err != nil {
+				return errors.Wrap(err, "consume [end]")
+			}
+		}
 	}
 
 	return nil
@@ -299,6 +649,11 @@ func (bf *BackupFile) Slurp() ([]*signal.BackupFrame, error) {
 				return nil, errors.Wrap(err, "failed to remove avatar")
 			}
 		}
+		if s := f.GetSticker(); s != nil {
+			if err = bf.DecryptAttachment(s.GetLength(), ioutil.Discard); err != nil {
+				return nil, errors.Wrap(err, "failed to remove sticker")
+			}
+		}
 	}
 }
 
@@ -0,0 +1,41 @@
+package types
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned while reading a backup. They are usually wrapped with more context, so should
+// be tested for with errors.Is.
+var (
+	// ErrBadMAC means a frame or attachment failed its integrity check, and the backup has been
+	// corrupted or tampered with.
+	ErrBadMAC = errors.New("bad MAC")
+
+	// ErrWrongPassword means the backup could not be decrypted with the given password.
+	ErrWrongPassword = errors.New("incorrect passphrase")
+
+	// ErrTruncated means the backup ended partway through a frame or attachment.
+	ErrTruncated = errors.New("backup file is truncated")
+
+	// ErrInvalidPassphrase means a passphrase isn't in the format Signal uses, so it can't be the
+	// right one.
+	ErrInvalidPassphrase = errors.New("passphrase must be 30 digits")
+
+	// ErrKeyMismatch means a saved key was derived for a different backup than the one it was used
+	// with.
+	ErrKeyMismatch = errors.New("key was derived for a different backup")
+
+	// ErrUnsupportedVersion means the backup was written in a newer format than can be read.
+	ErrUnsupportedVersion = errors.New("unsupported backup file version")
+)
+
+// truncated converts an unexpected end of file into ErrTruncated, leaving other errors as they
+// are.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
@@ -0,0 +1,289 @@
+package types
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/signal"
+)
+
+// FrameIndexVersion is the version of the frame index file layout.
+const FrameIndexVersion = 1
+
+// FrameIndex records where every frame in a backup is, so that any of them can be read without
+// decrypting everything before it.
+//
+// Decrypting a frame only needs its position and the value of the backup's counter when it was
+// written, so an index is only as secret as the backup's layout. The only things it keeps from the
+// frames themselves are their kind, and the ID and MIME type of attachments.
+type FrameIndex struct {
+	Version int               `json:"version"`
+	Salt    string            `json:"salt"`
+	Frames  []FrameIndexEntry `json:"frames"`
+
+	// Positions of attachments in Frames, by attachment ID.
+	attachments map[uint64]int
+}
+
+// FrameIndexEntry is the position of a single frame in a backup.
+type FrameIndexEntry struct {
+	Offset       int64  `json:"offset"`
+	Counter      uint32 `json:"counter"`
+	Kind         string `json:"kind"`
+	AttachmentID uint64 `json:"attachment_id,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Length       uint32 `json:"length,omitempty"`
+}
+
+// Index reads the position of every frame in the backup. The data of attachments, avatars and
+// stickers is skipped over without being decrypted or verified, so building an index is much
+// faster than reading the backup.
+//
+// The backup must be seekable, such as a file opened with NewBackupFile. Index starts from the
+// beginning whatever has already been read, and leaves the backup at its end.
+func (bf *BackupFile) Index() (*FrameIndex, error) {
+	if _, err := bf.seeker(); err != nil {
+		return nil, err
+	}
+	if err := bf.seek(bf.start, bf.startCounter, 0); err != nil {
+		return nil, err
+	}
+
+	idx := &FrameIndex{
+		Version: FrameIndexVersion,
+		Salt:    hex.EncodeToString(bf.Salt),
+		Frames:  []FrameIndexEntry{},
+	}
+	contentTypes := map[uint64]string{}
+
+	for {
+		e := FrameIndexEntry{Offset: bf.offset, Counter: bf.Counter}
+
+		f, err := bf.readFrame()
+		if err == io.EOF {
+			for i, e := range idx.Frames {
+				if e.Kind == FrameKindAttachment {
+					idx.Frames[i].ContentType = contentTypes[e.AttachmentID]
+				}
+			}
+			idx.indexAttachments()
+			return idx, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to index frame %d", len(idx.Frames))
+		}
+
+		if stmt := f.GetStatement(); strings.HasPrefix(stmt.GetStatement(), "INSERT INTO part ") {
+			if row, err := bf.Schema.Row(stmt); err == nil {
+				if part, err := RowToPart(row); err == nil && part.ContentType != nil {
+					contentTypes[part.UniqueID] = *part.ContentType
+				}
+			}
+		}
+
+		e.Kind = FrameKind(f)
+		e.AttachmentID = f.GetAttachment().GetAttachmentId()
+		e.Length = FrameDataLength(f)
+		idx.Frames = append(idx.Frames, e)
+
+		if e.Length > 0 {
+			if _, err = bf.SkipAttachment(e.Length); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Seek moves the backup to the nth frame of the index, so that it's returned by the next call to
+// Frame. Tables created before that frame won't have been seen, so Schema won't know about them.
+func (bf *BackupFile) Seek(idx *FrameIndex, n int) error {
+	if !idx.Matches(bf) {
+		return errors.New("index is for a different backup")
+	}
+	if n < 0 || n >= len(idx.Frames) {
+		return errors.Errorf("no frame %d in index", n)
+	}
+	e := idx.Frames[n]
+	return bf.seek(e.Offset, e.Counter, uint64(n))
+}
+
+// ExtractAttachment decrypts the attachment with the given ID straight to out, using idx to find
+// it.
+func (bf *BackupFile) ExtractAttachment(idx *FrameIndex, id uint64, out io.Writer) error {
+	n, ok := idx.Attachment(id)
+	if !ok {
+		return errors.Errorf("no attachment %d in index", id)
+	}
+	if err := bf.Seek(idx, n); err != nil {
+		return err
+	}
+
+	f, err := bf.Frame()
+	if err != nil {
+		return err
+	}
+	a := f.GetAttachment()
+	if a.GetAttachmentId() != id {
+		return errors.Errorf("index doesn't match backup; frame %d isn't attachment %d", n, id)
+	}
+	return bf.DecryptAttachment(a.GetLength(), out)
+}
+
+// AttachmentPosition is where the data of an attachment, avatar or sticker is in a backup.
+type AttachmentPosition struct {
+	Offset  int64
+	Counter uint32
+	Length  uint32
+}
+
+// SkipAttachment moves past the attachment immediately next in the file without decrypting it, and
+// returns where it is so that it can be decrypted later with DecryptAttachmentAt. The backup must
+// be seekable.
+func (bf *BackupFile) SkipAttachment(length uint32) (AttachmentPosition, error) {
+	pos := AttachmentPosition{Offset: bf.offset, Counter: bf.Counter, Length: length}
+	// The data is followed by its MAC.
+	if err := bf.seek(bf.offset+int64(length)+10, bf.Counter+1, bf.frames); err != nil {
+		return pos, err
+	}
+	return pos, nil
+}
+
+// DecryptAttachmentAt decrypts an attachment skipped over by SkipAttachment. It doesn't move
+// through the backup, so it can be called from several goroutines at once, and while frames are
+// being read.
+func (bf *BackupFile) DecryptAttachmentAt(pos AttachmentPosition, out io.Writer) error {
+	r, err := bf.AttachmentReaderAt(pos)
+	if err != nil {
+		return err
+	}
+	return copyAttachment(out, r)
+}
+
+// AttachmentReaderAt is like AttachmentReader, but for an attachment skipped over by
+// SkipAttachment. Like DecryptAttachmentAt, any number of these can be read at once.
+func (bf *BackupFile) AttachmentReaderAt(pos AttachmentPosition) (io.Reader, error) {
+	if pos.Length == 0 {
+		return nil, errors.New("can't read attachment of length 0")
+	}
+	ra, ok := bf.file.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("backup can't be read out of order; it must be read from a file")
+	}
+
+	r := io.NewSectionReader(ra, pos.Offset, int64(pos.Length)+10)
+	readFull := func(p []byte) (int, error) { return io.ReadFull(r, p) }
+
+	iv := append([]byte(nil), bf.startIV...)
+	uint32ToBytes(iv, pos.Counter)
+
+	dr, err := newAttachmentReader(readFull, bf.CipherKey, hmac.New(crypto.SHA256.New, bf.MacKey), iv, pos.Length)
+	if err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
+
+// Attachment returns the position in the index of the attachment with the given ID.
+func (idx *FrameIndex) Attachment(id uint64) (int, bool) {
+	if idx.attachments == nil {
+		idx.indexAttachments()
+	}
+	i, ok := idx.attachments[id]
+	return i, ok
+}
+
+// indexAttachments records the position of every attachment, so that Attachment doesn't have to
+// look through every frame. If an ID appears more than once, the first is used.
+func (idx *FrameIndex) indexAttachments() {
+	idx.attachments = map[uint64]int{}
+	for i, e := range idx.Frames {
+		if e.Kind != FrameKindAttachment {
+			continue
+		}
+		if _, ok := idx.attachments[e.AttachmentID]; !ok {
+			idx.attachments[e.AttachmentID] = i
+		}
+	}
+}
+
+// Matches reports whether the index is of bf. Every backup has its own random salt, so this tells
+// apart even backups of the same messages.
+func (idx *FrameIndex) Matches(bf *BackupFile) bool {
+	return idx.Salt == hex.EncodeToString(bf.Salt)
+}
+
+// Save writes the index as JSON.
+func (idx *FrameIndex) Save(w io.Writer) error {
+	return errors.Wrap(json.NewEncoder(w).Encode(idx), "failed to write index")
+}
+
+// LoadFrameIndex reads an index written by Save.
+func LoadFrameIndex(r io.Reader) (*FrameIndex, error) {
+	idx := new(FrameIndex)
+	if err := json.NewDecoder(r).Decode(idx); err != nil {
+		return nil, errors.Wrap(err, "failed to read index")
+	}
+	if idx.Version != FrameIndexVersion {
+		return nil, errors.Errorf("index version %d is not supported", idx.Version)
+	}
+	idx.indexAttachments()
+	return idx, nil
+}
+
+// FrameKind returns the kind of a frame, as used in NDJSON exports and indexes.
+func FrameKind(f *signal.BackupFrame) string {
+	switch {
+	case f.GetStatement() != nil:
+		return FrameKindStatement
+	case f.GetPreference() != nil:
+		return FrameKindPreference
+	case f.GetAttachment() != nil:
+		return FrameKindAttachment
+	case f.GetAvatar() != nil:
+		return FrameKindAvatar
+	case f.GetSticker() != nil:
+		return FrameKindSticker
+	case f.GetKeyValue() != nil:
+		return FrameKindKeyValue
+	case f.GetVersion() != nil:
+		return FrameKindVersion
+	case f.GetEnd():
+		return FrameKindEnd
+	}
+	return FrameKindUnknown
+}
+
+// Seekable reports whether the backup can be indexed and seeked.
+func (bf *BackupFile) Seekable() bool {
+	_, err := bf.seeker()
+	return err == nil
+}
+
+func (bf *BackupFile) seeker() (io.Seeker, error) {
+	s, ok := bf.file.(io.Seeker)
+	if !ok {
+		return nil, errors.New("backup can't be seeked; it must be read from a file")
+	}
+	return s, nil
+}
+
+// seek moves to offset in the file, and sets the counter and frame number to use for the frame
+// there.
+func (bf *BackupFile) seek(offset int64, counter uint32, frame uint64) error {
+	s, err := bf.seeker()
+	if err != nil {
+		return err
+	}
+	if _, err = s.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to seek")
+	}
+	bf.offset = offset
+	bf.Counter = counter
+	bf.frames = frame
+	bf.peeked = nil
+	return nil
+}
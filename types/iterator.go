@@ -0,0 +1,141 @@
+package types
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/signal"
+)
+
+// FrameIterator steps through the frames of a backup one at a time, for when the callbacks of
+// Consume don't fit.
+//
+//	it := bf.Frames(ctx)
+//	for it.Next() {
+//		f := it.Frame()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Unlike Consume, the iterator doesn't close the backup once it's done.
+type FrameIterator struct {
+	bf  *BackupFile
+	ctx context.Context
+
+	frame *signal.BackupFrame
+	err   error
+
+	// The data of the current frame, once it has been asked for.
+	data     io.Reader
+	dataRead bool
+}
+
+// Frames returns an iterator over the rest of the frames in the backup. Once ctx is done, the
+// iterator stops and Err returns the context's error.
+func (bf *BackupFile) Frames(ctx context.Context) *FrameIterator {
+	return &FrameIterator{bf: bf, ctx: ctx}
+}
+
+// Next moves to the next frame, reporting whether there is one. Any of the current frame's data
+// that hasn't been read is read and verified first.
+func (it *FrameIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.fail(err)
+		return false
+	}
+	if err := it.finishData(); err != nil {
+		it.fail(err)
+		return false
+	}
+
+	f, err := it.bf.Frame()
+	if err == io.EOF {
+		it.frame = nil
+		return false
+	} else if err != nil {
+		it.fail(err)
+		return false
+	}
+	it.frame = f
+	it.data, it.dataRead = nil, false
+	return true
+}
+
+// Frame returns the current frame.
+func (it *FrameIterator) Frame() *signal.BackupFrame {
+	return it.frame
+}
+
+// Data returns a reader for the attachment, avatar or sticker that follows the current frame, or
+// nil if it has none. The reader is only valid until the next call to Next.
+//
+// The MAC of the data can only be checked once all of it has been read, so if the reader returns
+// ErrBadMAC, everything read from it should be discarded.
+func (it *FrameIterator) Data() io.Reader {
+	if it.dataRead || it.frame == nil {
+		return it.data
+	}
+	it.dataRead = true
+
+	length := FrameDataLength(it.frame)
+	if length == 0 {
+		return nil
+	}
+	r, err := it.bf.AttachmentReader(length)
+	if err != nil {
+		r = errReader{err}
+	}
+	it.data = &contextReader{ctx: it.ctx, r: r}
+	return it.data
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *FrameIterator) Err() error {
+	return it.err
+}
+
+// finishData reads the rest of the current frame's data, so that the backup is ready for the next
+// frame.
+func (it *FrameIterator) finishData() error {
+	r := it.Data()
+	if r == nil {
+		return nil
+	}
+
+	_, err := io.Copy(ioutil.Discard, r)
+	return errors.Wrap(err, "failed to read frame data")
+}
+
+func (it *FrameIterator) fail(err error) {
+	it.err = err
+	it.frame = nil
+	it.data = nil
+}
+
+// contextReader stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
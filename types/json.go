@@ -0,0 +1,193 @@
+package types
+
+import (
+	"sort"
+)
+
+// JSONSchemaVersion is the version of the JSON export layout. It is bumped whenever a field is
+// removed or changes meaning; adding fields does not change the version.
+//
+// The schema itself is documented in docs/json-schema-v1.json.
+const JSONSchemaVersion = 1
+
+// Message kinds used in the JSON export.
+const (
+	JSONKindSMS = "sms"
+	JSONKindMMS = "mms"
+)
+
+// Message directions used in the JSON export.
+const (
+	JSONDirectionIncoming = "incoming"
+	JSONDirectionOutgoing = "outgoing"
+	JSONDirectionDraft    = "draft"
+	JSONDirectionUnknown  = "unknown"
+)
+
+// JSONBackup is the top-level object of the JSON export.
+type JSONBackup struct {
+	SchemaVersion int          `json:"schema_version"`
+	Threads       []JSONThread `json:"threads"`
+}
+
+// JSONThread holds every message belonging to a single conversation.
+type JSONThread struct {
+	ID       uint64        `json:"id"`
+	Messages []JSONMessage `json:"messages"`
+}
+
+// JSONMessage is a single SMS or MMS. Fields that only apply to one kind are omitted for the
+// other.
+type JSONMessage struct {
+	Kind         string     `json:"kind"`
+	ID           uint64     `json:"id"`
+	ThreadID     uint64     `json:"thread_id"`
+	Address      *string    `json:"address"`
+	DateSent     *uint64    `json:"date_sent"`
+	DateReceived *uint64    `json:"date_received"`
+	Type         uint64     `json:"type"`
+	Direction    string     `json:"direction"`
+	Read         bool       `json:"read"`
+	Subject      *string    `json:"subject,omitempty"`
+	Body         *string    `json:"body"`
+	ExpiresIn    uint64     `json:"expires_in"`
+	Parts        []JSONPart `json:"parts,omitempty"`
+}
+
+// JSONPart is an MMS part. Attachment is only set if the backup contained the part's data.
+type JSONPart struct {
+	ID          uint64          `json:"id"`
+	UniqueID    uint64          `json:"unique_id"`
+	Seq         uint64          `json:"seq"`
+	ContentType *string         `json:"content_type"`
+	Name        *string         `json:"name"`
+	FileName    *string         `json:"file_name"`
+	Size        *uint64         `json:"size"`
+	Width       uint64          `json:"width"`
+	Height      uint64          `json:"height"`
+	VoiceNote   bool            `json:"voice_note"`
+	Caption     *string         `json:"caption"`
+	Attachment  *JSONAttachment `json:"attachment"`
+}
+
+// JSONAttachment describes the binary data of a part as it was found in the backup.
+type JSONAttachment struct {
+	ID     uint64 `json:"id"`
+	Length uint32 `json:"length"`
+}
+
+// NewJSONSMS converts an SMS database row to its JSON representation.
+func NewJSONSMS(sms *SQLSMS) JSONMessage {
+	m := JSONMessage{
+		Kind:         JSONKindSMS,
+		ID:           sms.ID,
+		Address:      sms.Address,
+		DateSent:     sms.DateSent,
+		DateReceived: sms.DateReceived,
+		Direction:    JSONDirectionUnknown,
+		Read:         sms.Read != 0,
+		Subject:      sms.Subject,
+		Body:         sms.Body,
+		ExpiresIn:    sms.ExpiresIn,
+	}
+	if sms.ThreadID != nil {
+		m.ThreadID = *sms.ThreadID
+	}
+	if sms.Type != nil {
+		m.Type = *sms.Type
+		m.Direction = messageDirection(*sms.Type)
+	}
+	return m
+}
+
+// NewJSONMMS converts an MMS database row to its JSON representation. Parts are added separately
+// as they are stored in their own table.
+func NewJSONMMS(mms *SQLMMS) JSONMessage {
+	m := JSONMessage{
+		Kind:         JSONKindMMS,
+		ID:           mms.ID,
+		Address:      mms.Address,
+		DateSent:     mms.DateSent,
+		DateReceived: mms.DateReceived,
+		Direction:    JSONDirectionUnknown,
+		Read:         mms.Read != 0,
+		Body:         mms.Body,
+		ExpiresIn:    mms.ExpiresIn,
+	}
+	if mms.ThreadID != nil {
+		m.ThreadID = *mms.ThreadID
+	}
+	if mms.MessageBox != nil {
+		m.Type = *mms.MessageBox
+		m.Direction = messageDirection(*mms.MessageBox)
+	}
+	return m
+}
+
+// NewJSONPart converts a part database row to its JSON representation.
+func NewJSONPart(part *SQLPart) JSONPart {
+	return JSONPart{
+		ID:          part.RowID,
+		UniqueID:    part.UniqueID,
+		Seq:         part.Seq,
+		ContentType: part.ContentType,
+		Name:        part.Name,
+		FileName:    part.FileName,
+		Size:        part.Size,
+		Width:       part.Width,
+		Height:      part.Height,
+		VoiceNote:   part.VoiceNote != 0,
+		Caption:     part.Caption,
+	}
+}
+
+// GroupJSONThreads nests messages into their threads. Threads are ordered by ID, and messages
+// within a thread by the date they were received.
+func GroupJSONThreads(msgs []JSONMessage) []JSONThread {
+	byID := map[uint64]*JSONThread{}
+	ids := []uint64{}
+
+	for _, m := range msgs {
+		t, ok := byID[m.ThreadID]
+		if !ok {
+			t = &JSONThread{ID: m.ThreadID}
+			byID[m.ThreadID] = t
+			ids = append(ids, m.ThreadID)
+		}
+		t.Messages = append(t.Messages, m)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	threads := make([]JSONThread, 0, len(ids))
+	for _, id := range ids {
+		t := byID[id]
+		sort.SliceStable(t.Messages, func(i, j int) bool {
+			return derefUint64(t.Messages[i].DateReceived) < derefUint64(t.Messages[j].DateReceived)
+		})
+		threads = append(threads, *t)
+	}
+	return threads
+}
+
+// messageDirection is a non-fatal version of translateSMSType, used where an unknown type should
+// not stop the export.
+func messageDirection(t uint64) string {
+	switch uint8(t) & 0x1F {
+	case 1, 20:
+		return JSONDirectionIncoming
+	case 2, 4, 5, 6, 21, 22, 23, 24, 25, 26:
+		return JSONDirectionOutgoing
+	case 3, 27:
+		return JSONDirectionDraft
+	default:
+		return JSONDirectionUnknown
+	}
+}
+
+func derefUint64(n *uint64) uint64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
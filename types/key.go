@@ -0,0 +1,115 @@
+package types
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// PassphraseLength is the number of digits in a backup passphrase.
+const PassphraseLength = 30
+
+// NormalisePassphrase removes the spaces and dashes a passphrase is often written with, such as
+// when it's shown in groups of five digits, and checks that what's left is 30 digits. The error
+// doesn't include the passphrase.
+func NormalisePassphrase(pass string) (string, error) {
+	digits := make([]rune, 0, PassphraseLength)
+	for _, r := range pass {
+		switch {
+		case unicode.IsSpace(r) || r == '-':
+			continue
+		case r >= '0' && r <= '9':
+			digits = append(digits, r)
+		default:
+			return "", errors.Wrap(ErrInvalidPassphrase, "found something other than digits")
+		}
+	}
+	if len(digits) != PassphraseLength {
+		return "", errors.Wrapf(ErrInvalidPassphrase, "found %d digits", len(digits))
+	}
+	return string(digits), nil
+}
+
+// BackupKeyVersion is the version of the key file layout.
+const BackupKeyVersion = 1
+
+// BackupKey holds the keys a backup is encrypted with. They are derived from the password and the
+// backup's salt, which is deliberately slow, so a BackupKey can be saved to open the same backup
+// again without the password.
+//
+// Anyone with the key can read the backup, so it needs to be kept as safe as the password.
+type BackupKey struct {
+	Salt      []byte
+	CipherKey []byte
+	MacKey    []byte
+}
+
+// DeriveBackupKey derives the keys for the backup with the given salt from its password.
+func DeriveBackupKey(password string, salt []byte) *BackupKey {
+	derived := deriveSecrets(backupKey(password, salt), []byte("Backup Export"))
+	return &BackupKey{
+		Salt:      salt,
+		CipherKey: derived[:32],
+		MacKey:    derived[32:],
+	}
+}
+
+// Key returns the keys the backup is encrypted with.
+func (bf *BackupFile) Key() *BackupKey {
+	return &BackupKey{Salt: bf.Salt, CipherKey: bf.CipherKey, MacKey: bf.MacKey}
+}
+
+// keyFile is how a BackupKey is saved.
+type keyFile struct {
+	Version   int    `json:"version"`
+	Salt      string `json:"salt"`
+	CipherKey string `json:"cipher_key"`
+	MacKey    string `json:"mac_key"`
+}
+
+// Save writes the key as JSON.
+func (k *BackupKey) Save(w io.Writer) error {
+	err := json.NewEncoder(w).Encode(keyFile{
+		Version:   BackupKeyVersion,
+		Salt:      hex.EncodeToString(k.Salt),
+		CipherKey: hex.EncodeToString(k.CipherKey),
+		MacKey:    hex.EncodeToString(k.MacKey),
+	})
+	return errors.Wrap(err, "failed to write key")
+}
+
+// LoadBackupKey reads a key written by Save.
+func LoadBackupKey(r io.Reader) (*BackupKey, error) {
+	var kf keyFile
+	if err := json.NewDecoder(r).Decode(&kf); err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+	if kf.Version != BackupKeyVersion {
+		return nil, errors.Errorf("key version %d is not supported", kf.Version)
+	}
+
+	k := new(BackupKey)
+	for _, f := range []struct {
+		name string
+		hex  string
+		out  *[]byte
+		size int
+	}{
+		{"salt", kf.Salt, &k.Salt, 0},
+		{"cipher key", kf.CipherKey, &k.CipherKey, 32},
+		{"MAC key", kf.MacKey, &k.MacKey, 32},
+	} {
+		bs, err := hex.DecodeString(f.hex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", f.name)
+		}
+		if f.size > 0 && len(bs) != f.size {
+			return nil, errors.Errorf("%s is %d bytes long, not %d", f.name, len(bs), f.size)
+		}
+		*f.out = bs
+	}
+	return k, nil
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"github.com/xeals/signal-back/signal"
+)
+
+// Frame kinds used in the NDJSON event feed.
+const (
+	FrameKindStatement  = "statement"
+	FrameKindPreference = "preference"
+	FrameKindAttachment = "attachment"
+	FrameKindAvatar     = "avatar"
+	FrameKindSticker    = "sticker"
+	FrameKindKeyValue   = "key_value"
+	FrameKindVersion    = "version"
+	FrameKindEnd        = "end"
+	FrameKindUnknown    = "unknown"
+)
+
+// SQL parameter types used in the NDJSON event feed.
+const (
+	ParameterString  = "string"
+	ParameterInteger = "integer"
+	ParameterDouble  = "double"
+	ParameterBlob    = "blob"
+	ParameterNull    = "null"
+)
+
+// FrameEvent is a single decoded BackupFrame, flattened for JSON encoding. Only the fields
+// relevant to its Kind are set.
+type FrameEvent struct {
+	Index uint64 `json:"index"`
+	Kind  string `json:"frame"`
+
+	// statement
+	SQL        *string          `json:"sql,omitempty"`
+	Parameters []ParameterEvent `json:"parameters,omitempty"`
+
+	// preference, key value
+	File  *string `json:"file,omitempty"`
+	Key   *string `json:"key,omitempty"`
+	Value *string `json:"value,omitempty"`
+
+	// key value
+	BlobValue    []byte   `json:"blob_value,omitempty"`
+	BooleanValue *bool    `json:"boolean_value,omitempty"`
+	FloatValue   *float32 `json:"float_value,omitempty"`
+	IntegerValue *int32   `json:"integer_value,omitempty"`
+	LongValue    *int64   `json:"long_value,omitempty"`
+	StringValue  *string  `json:"string_value,omitempty"`
+
+	// attachment, avatar, sticker
+	RowID        *uint64 `json:"row_id,omitempty"`
+	AttachmentID *uint64 `json:"attachment_id,omitempty"`
+	Name         *string `json:"name,omitempty"`
+	Length       *uint32 `json:"length,omitempty"`
+
+	// version
+	Version *uint32 `json:"version,omitempty"`
+}
+
+// ParameterEvent is a single SQL parameter. Integers are signed, as that is how Signal stores
+// them; blobs are encoded in base64 by encoding/json.
+type ParameterEvent struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// NewFrameEvent flattens a frame into an event. The index should be the position of the frame
+// in the backup.
+func NewFrameEvent(index uint64, f *signal.BackupFrame) *FrameEvent {
+	ev := &FrameEvent{Index: index, Kind: FrameKindUnknown}
+
+	if s := f.GetStatement(); s != nil {
+		ev.Kind = FrameKindStatement
+		ev.SQL = s.Statement
+		ev.Parameters = make([]ParameterEvent, len(s.GetParameters()))
+		for i, p := range s.GetParameters() {
+			ev.Parameters[i] = NewParameterEvent(p)
+		}
+	} else if p := f.GetPreference(); p != nil {
+		ev.Kind = FrameKindPreference
+		ev.File = p.File
+		ev.Key = p.Key
+		ev.Value = p.Value
+	} else if a := f.GetAttachment(); a != nil {
+		ev.Kind = FrameKindAttachment
+		ev.RowID = a.RowId
+		ev.AttachmentID = a.AttachmentId
+		ev.Length = a.Length
+	} else if a := f.GetAvatar(); a != nil {
+		ev.Kind = FrameKindAvatar
+		ev.Name = a.Name
+		ev.Length = a.Length
+	} else if s := f.GetSticker(); s != nil {
+		ev.Kind = FrameKindSticker
+		ev.RowID = s.RowId
+		ev.Length = s.Length
+	} else if kv := f.GetKeyValue(); kv != nil {
+		ev.Kind = FrameKindKeyValue
+		ev.Key = kv.Key
+		ev.BlobValue = kv.BlobValue
+		ev.BooleanValue = kv.BooleanValue
+		ev.FloatValue = kv.FloatValue
+		ev.IntegerValue = kv.IntegerValue
+		ev.LongValue = kv.LongValue
+		ev.StringValue = kv.StringValue
+	} else if v := f.GetVersion(); v != nil {
+		ev.Kind = FrameKindVersion
+		ev.Version = v.Version
+	} else if f.GetEnd() {
+		ev.Kind = FrameKindEnd
+	}
+
+	return ev
+}
+
+// NewParameterEvent converts a SQL parameter into its tagged JSON form.
+func NewParameterEvent(p *signal.SqlStatement_SqlParameter) ParameterEvent {
+	switch {
+	case p.StringParamter != nil:
+		return ParameterEvent{ParameterString, *p.StringParamter}
+	case p.IntegerParameter != nil:
+		return ParameterEvent{ParameterInteger, int64(*p.IntegerParameter)}
+	case p.DoubleParameter != nil:
+		return ParameterEvent{ParameterDouble, *p.DoubleParameter}
+	case p.BlobParameter != nil:
+		return ParameterEvent{ParameterBlob, p.BlobParameter}
+	default:
+		return ParameterEvent{ParameterNull, nil}
+	}
+}
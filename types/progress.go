@@ -0,0 +1,72 @@
+package types
+
+import (
+	"time"
+)
+
+// ProgressInterval is roughly how often a backup's OnProgress is called while it's being read.
+const ProgressInterval = 100 * time.Millisecond
+
+// Progress is how far through a backup reading has got.
+type Progress struct {
+	// BytesRead counts everything read from the start of the backup, including its header.
+	BytesRead int64
+	// TotalBytes is the size of the backup, or zero if it isn't known.
+	TotalBytes int64
+	Frames     uint64
+	// Attachments counts every frame with data after it: attachments, avatars and stickers.
+	Attachments uint64
+	Elapsed     time.Duration
+	// Done is set once the end of the backup has been reached.
+	Done bool
+}
+
+// ETA estimates how long is left, assuming the rest of the backup is read as fast as it has been
+// so far. It's zero if the size of the backup isn't known or nothing has been read yet.
+func (p Progress) ETA() time.Duration {
+	if p.Done || p.TotalBytes <= 0 || p.BytesRead <= 0 || p.BytesRead >= p.TotalBytes {
+		return 0
+	}
+	left := float64(p.TotalBytes-p.BytesRead) / float64(p.BytesRead)
+	return time.Duration(float64(p.Elapsed) * left)
+}
+
+// Fraction returns how much of the backup has been read, from 0 to 1, or -1 if its size isn't
+// known.
+func (p Progress) Fraction() float64 {
+	if p.Done {
+		return 1
+	}
+	if p.TotalBytes <= 0 {
+		return -1
+	}
+	if p.BytesRead >= p.TotalBytes {
+		return 1
+	}
+	return float64(p.BytesRead) / float64(p.TotalBytes)
+}
+
+// reportProgress calls OnProgress, unless it has been called too recently.
+func (bf *BackupFile) reportProgress(done bool) {
+	if bf.OnProgress == nil {
+		return
+	}
+
+	now := time.Now()
+	if bf.progressStart.IsZero() {
+		bf.progressStart = now
+	}
+	if !done && now.Sub(bf.progressLast) < ProgressInterval {
+		return
+	}
+	bf.progressLast = now
+
+	bf.OnProgress(Progress{
+		BytesRead:   bf.offset,
+		TotalBytes:  bf.FileSize,
+		Frames:      bf.frames,
+		Attachments: bf.attachments,
+		Elapsed:     now.Sub(bf.progressStart),
+		Done:        done,
+	})
+}
@@ -20,7 +20,9 @@ func StatementToStringArray(sql *signal.SqlStatement) []string {
 	return s
 }
 
-// CSV column headers.
+// CSV column headers, for the layout of the sms and mms tables from older versions of Signal.
+//
+// Deprecated: The CSV format uses the column names of the backup's own tables.
 var (
 	SMSCSVHeaders = []string{
 		"ID",
@@ -161,44 +163,61 @@ type SQLSMS struct {
 	Unidentified         uint64 // default 0
 }
 
-// StatementToSMS converts a of SQL statement to a single SMS.
+// RowToSMS converts a row of the sms table to a single SMS. The columns that every export of a
+// message relies on must be present; the rest are left as zero values if they're missing.
+func RowToSMS(r *Row) (*SQLSMS, error) {
+	if err := r.Require("_id", "thread_id", "address", "date", "date_sent", "type", "body"); err != nil {
+		return nil, err
+	}
+
+	return &SQLSMS{
+		ID:                   r.Param("_id").GetIntegerParameter(),
+		ThreadID:             r.Integer("thread_id"),
+		Address:              r.String("address"),
+		AddressDeviceID:      r.Param("address_device_id").GetIntegerParameter(),
+		Person:               r.Integer("person"),
+		DateReceived:         r.Integer("date"),
+		DateSent:             r.Integer("date_sent"),
+		Protocol:             r.Param("protocol").GetIntegerParameter(),
+		Read:                 r.Param("read").GetIntegerParameter(),
+		Status:               r.Param("status").GetIntegerParameter(),
+		Type:                 r.Integer("type"),
+		ReplyPathPresent:     r.Integer("reply_path_present"),
+		DeliveryReceiptCount: r.Param("delivery_receipt_count").GetIntegerParameter(),
+		Subject:              r.String("subject"),
+		Body:                 r.String("body"),
+		MismatchedIdentities: r.String("mismatched_identities"),
+		ServiceCenter:        r.String("service_center"),
+		SubscriptionID:       r.Param("subscription_id").GetIntegerParameter(),
+		ExpiresIn:            r.Param("expires_in").GetIntegerParameter(),
+		ExpireStarted:        r.Param("expire_started").GetIntegerParameter(),
+		Notified:             r.Param("notified").GetIntegerParameter(),
+		ReadReceiptCount:     r.Param("read_receipt_count").GetIntegerParameter(),
+		Unidentified:         r.Param("unidentified").GetIntegerParameter(),
+	}, nil
+}
+
+// StatementToSMS converts a SQL statement to a single SMS, assuming the layout of the sms table
+// from older versions of Signal. It returns nil if the statement can't be decoded.
+//
+// Deprecated: Use Schema.Row and RowToSMS, which use the backup's own layout.
 func StatementToSMS(sql *signal.SqlStatement) *SQLSMS {
 	return ParametersToSMS(sql.GetParameters())
 }
 
-// ParametersToSMS converts a set of SQL parameters to a single SMS.
+// ParametersToSMS converts a set of SQL parameters to a single SMS, as StatementToSMS does.
+//
+// Deprecated: Use Schema.Row and RowToSMS, which use the backup's own layout.
 func ParametersToSMS(ps []*signal.SqlStatement_SqlParameter) *SQLSMS {
-	if len(ps) < 22 {
+	r := legacyRow("sms", ps)
+	if r == nil {
 		return nil
 	}
-
-	result := &SQLSMS{
-		ID:                   ps[0].GetIntegerParameter(),
-		ThreadID:             ps[1].IntegerParameter,
-		Address:              ps[2].StringParamter,
-		AddressDeviceID:      ps[3].GetIntegerParameter(),
-		Person:               ps[4].IntegerParameter,
-		DateReceived:         ps[5].IntegerParameter,
-		DateSent:             ps[6].IntegerParameter,
-		Protocol:             ps[7].GetIntegerParameter(),
-		Read:                 ps[8].GetIntegerParameter(),
-		Status:               ps[9].GetIntegerParameter(),
-		Type:                 ps[10].IntegerParameter,
-		ReplyPathPresent:     ps[11].IntegerParameter,
-		DeliveryReceiptCount: ps[12].GetIntegerParameter(),
-		Subject:              ps[13].StringParamter,
-		Body:                 ps[14].StringParamter,
-		MismatchedIdentities: ps[15].StringParamter,
-		ServiceCenter:        ps[16].StringParamter,
-		SubscriptionID:       ps[17].GetIntegerParameter(),
-		ExpiresIn:            ps[18].GetIntegerParameter(),
-		ExpireStarted:        ps[19].GetIntegerParameter(),
-		Notified:             ps[20].GetIntegerParameter(),
-		ReadReceiptCount:     ps[21].GetIntegerParameter(),
-		//Unidentified:         ps[22].GetIntegerParameter(),
+	sms, err := RowToSMS(r)
+	if err != nil {
+		return nil
 	}
-
-	return result
+	return sms
 }
 
 // SQLMMS info
@@ -256,70 +275,87 @@ type SQLMMS struct {
 	Unidentified         uint64 // default 0
 }
 
-// StatementToMMS converts a of SQL statement to a single MMS.
+// RowToMMS converts a row of the mms table to a single MMS. The columns that every export of a
+// message relies on must be present; the rest are left as zero values if they're missing.
+func RowToMMS(r *Row) (*SQLMMS, error) {
+	if err := r.Require("_id", "thread_id", "address", "date", "date_received", "msg_box", "body"); err != nil {
+		return nil, err
+	}
+
+	return &SQLMMS{
+		ID:                   r.Param("_id").GetIntegerParameter(),
+		ThreadID:             r.Integer("thread_id"),
+		DateSent:             r.Integer("date"),
+		DateReceived:         r.Integer("date_received"),
+		MessageBox:           r.Integer("msg_box"),
+		Read:                 r.Param("read").GetIntegerParameter(),
+		MID:                  r.String("m_id"),
+		Sub:                  r.String("sub"),
+		SubCs:                r.Integer("sub_cs"),
+		Body:                 r.String("body"),
+		PartCount:            r.Integer("part_count"),
+		CtT:                  r.String("ct_t"),
+		ContentLocation:      r.String("ct_l"),
+		Address:              r.String("address"),
+		AddressDeviceID:      r.Integer("address_device_id"),
+		Expiry:               r.Integer("exp"),
+		MCls:                 r.String("m_cls"),
+		MessageType:          r.Integer("m_type"),
+		V:                    r.Integer("v"),
+		MessageSize:          r.Integer("m_size"),
+		Pri:                  r.Integer("pri"),
+		Rr:                   r.Integer("rr"),
+		RptA:                 r.Integer("rpt_a"),
+		RespSt:               r.Integer("resp_st"),
+		Status:               r.Integer("st"),
+		TransactionID:        r.String("tr_id"),
+		RetrSt:               r.Integer("retr_st"),
+		RetrTxt:              r.String("retr_txt"),
+		RetrTxtCs:            r.Integer("retr_txt_cs"),
+		ReadStatus:           r.Integer("read_status"),
+		CtCls:                r.Integer("ct_cls"),
+		RespTxt:              r.String("resp_txt"),
+		DTm:                  r.Integer("d_tm"),
+		DeliveryReceiptCount: r.Param("delivery_receipt_count").GetIntegerParameter(),
+		MismatchedIdentities: r.String("mismatched_identities"),
+		NetworkFailure:       r.String("network_failures"),
+		DRpt:                 r.Integer("d_rpt"),
+		SubscriptionID:       r.Param("subscription_id").GetIntegerParameter(),
+		ExpiresIn:            r.Param("expires_in").GetIntegerParameter(),
+		ExpireStarted:        r.Param("expire_started").GetIntegerParameter(),
+		Notified:             r.Param("notified").GetIntegerParameter(),
+		ReadReceiptCount:     r.Param("read_receipt_count").GetIntegerParameter(),
+		QuoteID:              r.Param("quote_id").GetIntegerParameter(),
+		QuoteAuthor:          r.String("quote_author"),
+		QuoteBody:            r.String("quote_body"),
+		QuoteAttachment:      r.Param("quote_attachment").GetIntegerParameter(),
+		QuoteMissing:         r.Param("quote_missing").GetIntegerParameter(),
+		SharedContacts:       r.String("shared_contacts"),
+		Unidentified:         r.Param("unidentified").GetIntegerParameter(),
+	}, nil
+}
+
+// StatementToMMS converts a SQL statement to a single MMS, assuming the layout of the mms table
+// from older versions of Signal. It returns nil if the statement can't be decoded.
+//
+// Deprecated: Use Schema.Row and RowToMMS, which use the backup's own layout.
 func StatementToMMS(sql *signal.SqlStatement) *SQLMMS {
 	return ParametersToMMS(sql.GetParameters())
 }
 
-// ParametersToMMS converts a set of SQL parameters to a single MMS.
+// ParametersToMMS converts a set of SQL parameters to a single MMS, as StatementToMMS does.
+//
+// Deprecated: Use Schema.Row and RowToMMS, which use the backup's own layout.
 func ParametersToMMS(ps []*signal.SqlStatement_SqlParameter) *SQLMMS {
-	if len(ps) < 42 {
+	r := legacyRow("mms", ps)
+	if r == nil {
 		return nil
 	}
-
-	result := &SQLMMS{
-		ID:                   ps[0].GetIntegerParameter(),
-		ThreadID:             ps[1].IntegerParameter,
-		DateSent:             ps[2].IntegerParameter,
-		DateReceived:         ps[3].IntegerParameter,
-		MessageBox:           ps[4].IntegerParameter,
-		Read:                 ps[5].GetIntegerParameter(),
-		MID:                  ps[6].StringParamter,
-		Sub:                  ps[7].StringParamter,
-		SubCs:                ps[8].IntegerParameter,
-		Body:                 ps[9].StringParamter,
-		PartCount:            ps[10].IntegerParameter,
-		CtT:                  ps[11].StringParamter,
-		ContentLocation:      ps[12].StringParamter,
-		Address:              ps[13].StringParamter,
-		AddressDeviceID:      ps[14].IntegerParameter,
-		Expiry:               ps[15].IntegerParameter,
-		MCls:                 ps[16].StringParamter,
-		MessageType:          ps[17].IntegerParameter,
-		V:                    ps[18].IntegerParameter,
-		MessageSize:          ps[19].IntegerParameter,
-		Pri:                  ps[20].IntegerParameter,
-		Rr:                   ps[21].IntegerParameter,
-		RptA:                 ps[22].IntegerParameter,
-		RespSt:               ps[23].IntegerParameter,
-		Status:               ps[24].IntegerParameter,
-		TransactionID:        ps[25].StringParamter,
-		RetrSt:               ps[26].IntegerParameter,
-		RetrTxt:              ps[27].StringParamter,
-		RetrTxtCs:            ps[28].IntegerParameter,
-		ReadStatus:           ps[29].IntegerParameter,
-		CtCls:                ps[30].IntegerParameter,
-		RespTxt:              ps[31].StringParamter,
-		DTm:                  ps[32].IntegerParameter,
-		DeliveryReceiptCount: ps[33].GetIntegerParameter(),
-		MismatchedIdentities: ps[34].StringParamter,
-		NetworkFailure:       ps[35].StringParamter,
-		DRpt:                 ps[36].IntegerParameter,
-		SubscriptionID:       ps[37].GetIntegerParameter(),
-		ExpiresIn:            ps[38].GetIntegerParameter(),
-		ExpireStarted:        ps[39].GetIntegerParameter(),
-		Notified:             ps[40].GetIntegerParameter(),
-		ReadReceiptCount:     ps[41].GetIntegerParameter(),
-		//QuoteID:              ps[42].GetIntegerParameter(),
-		//QuoteAuthor:          ps[43].StringParamter,
-		//QuoteBody:            ps[44].StringParamter,
-		//QuoteAttachment:      ps[45].GetIntegerParameter(),
-		//QuoteMissing:         ps[46].GetIntegerParameter(),
-		//SharedContacts:       ps[47].StringParamter,
-		//Unidentified:         ps[48].GetIntegerParameter(),
+	mms, err := RowToMMS(r)
+	if err != nil {
+		return nil
 	}
-
-	return result
+	return mms
 }
 
 // SQLPart info
@@ -357,45 +393,104 @@ type SQLPart struct {
 	Caption              *string //default null
 }
 
-// StatementToPart converts a of SQL statement to a single part.
+// RowToPart converts a row of the part table to a single part.
+func RowToPart(r *Row) (*SQLPart, error) {
+	if err := r.Require("_id", "mid", "unique_id"); err != nil {
+		return nil, err
+	}
+
+	return &SQLPart{
+		RowID:                r.Param("_id").GetIntegerParameter(),
+		MmsID:                r.Integer("mid"),
+		Seq:                  r.Param("seq").GetIntegerParameter(),
+		ContentType:          r.String("ct"),
+		Name:                 r.String("name"),
+		Chset:                r.Integer("chset"),
+		ContentDisposition:   r.String("cd"),
+		Fn:                   r.String("fn"),
+		Cid:                  r.String("cid"),
+		ContentLocation:      r.String("cl"),
+		CttS:                 r.Integer("ctt_s"),
+		CttT:                 r.String("ctt_t"),
+		encrypted:            r.Integer("encrypted"),
+		TransferState:        r.Integer("pending_push"),
+		Data:                 r.String("_data"),
+		Size:                 r.Integer("data_size"),
+		FileName:             r.String("file_name"),
+		Thumbnail:            r.String("thumbnail"),
+		ThumbnailAspectRatio: r.Double("aspect_ratio"),
+		UniqueID:             r.Param("unique_id").GetIntegerParameter(),
+		Digest:               r.Blob("digest"),
+		FastPreflightID:      r.String("fast_preflight_id"),
+		VoiceNote:            r.Param("voice_note").GetIntegerParameter(),
+		DataRandom:           r.Blob("data_random"),
+		ThumbnailRandom:      r.Blob("thumbnail_random"),
+		Quote:                r.Param("quote").GetIntegerParameter(),
+		Width:                r.Param("width").GetIntegerParameter(),
+		Height:               r.Param("height").GetIntegerParameter(),
+		Caption:              r.String("caption"),
+	}, nil
+}
+
+// StatementToPart converts a SQL statement to a single part, assuming the layout of the part
+// table from older versions of Signal. It returns nil if the statement can't be decoded.
+//
+// Deprecated: Use Schema.Row and RowToPart, which use the backup's own layout.
 func StatementToPart(sql *signal.SqlStatement) *SQLPart {
 	return ParametersToPart(sql.GetParameters())
 }
 
-// ParametersToPart converts a set of SQL parameters to a single part.
+// ParametersToPart converts a set of SQL parameters to a single part, as StatementToPart does.
+//
+// Deprecated: Use Schema.Row and RowToPart, which use the backup's own layout.
 func ParametersToPart(ps []*signal.SqlStatement_SqlParameter) *SQLPart {
-	if len(ps) < 25 {
+	r := legacyRow("part", ps)
+	if r == nil {
 		return nil
 	}
-	return &SQLPart{
-		RowID:                ps[0].GetIntegerParameter(),
-		MmsID:                ps[1].IntegerParameter,
-		Seq:                  ps[2].GetIntegerParameter(),
-		ContentType:          ps[3].StringParamter,
-		Name:                 ps[4].StringParamter,
-		Chset:                ps[5].IntegerParameter,
-		ContentDisposition:   ps[6].StringParamter,
-		Fn:                   ps[7].StringParamter,
-		Cid:                  ps[8].StringParamter,
-		ContentLocation:      ps[9].StringParamter,
-		CttS:                 ps[10].IntegerParameter,
-		CttT:                 ps[11].StringParamter,
-		encrypted:            ps[12].IntegerParameter,
-		TransferState:        ps[13].IntegerParameter,
-		Data:                 ps[14].StringParamter,
-		Size:                 ps[15].IntegerParameter,
-		FileName:             ps[16].StringParamter,
-		Thumbnail:            ps[17].StringParamter,
-		ThumbnailAspectRatio: ps[18].DoubleParameter,
-		UniqueID:             ps[19].GetIntegerParameter(),
-		Digest:               ps[20].GetBlobParameter(),
-		FastPreflightID:      ps[21].StringParamter,
-		VoiceNote:            ps[22].GetIntegerParameter(),
-		DataRandom:           ps[23].GetBlobParameter(),
-		ThumbnailRandom:      ps[24].GetBlobParameter(),
-		Quote:                ps[25].GetIntegerParameter(),
-		Width:                ps[26].GetIntegerParameter(),
-		Height:               ps[27].GetIntegerParameter(),
-		//Caption:              ps[28].StringParamter,
+	part, err := RowToPart(r)
+	if err != nil {
+		return nil
+	}
+	return part
+}
+
+// legacyColumns are the leading columns of the sms, mms and part tables in the versions of Signal
+// that rows were decoded by position for, before the layout was read from the backup.
+var legacyColumns = map[string][]string{
+	"sms": {
+		"_id", "thread_id", "address", "address_device_id", "person", "date", "date_sent",
+		"protocol", "read", "status", "type", "reply_path_present", "delivery_receipt_count",
+		"subject", "body", "mismatched_identities", "service_center", "subscription_id",
+		"expires_in", "expire_started", "notified", "read_receipt_count",
+	},
+	"mms": {
+		"_id", "thread_id", "date", "date_received", "msg_box", "read", "m_id", "sub", "sub_cs",
+		"body", "part_count", "ct_t", "ct_l", "address", "address_device_id", "exp", "m_cls",
+		"m_type", "v", "m_size", "pri", "rr", "rpt_a", "resp_st", "st", "tr_id", "retr_st",
+		"retr_txt", "retr_txt_cs", "read_status", "ct_cls", "resp_txt", "d_tm",
+		"delivery_receipt_count", "mismatched_identities", "network_failures", "d_rpt",
+		"subscription_id", "expires_in", "expire_started", "notified", "read_receipt_count",
+	},
+	"part": {
+		"_id", "mid", "seq", "ct", "name", "chset", "cd", "fn", "cid", "cl", "ctt_s", "ctt_t",
+		"encrypted", "pending_push", "_data", "data_size", "file_name", "thumbnail",
+		"aspect_ratio", "unique_id", "digest", "fast_preflight_id", "voice_note", "data_random",
+		"thumbnail_random", "quote", "width", "height",
+	},
+}
+
+// legacyRow decodes a statement by position using legacyColumns, or returns nil if it has fewer
+// values than the table had columns. Any values after those are ignored.
+func legacyRow(table string, ps []*signal.SqlStatement_SqlParameter) *Row {
+	columns := legacyColumns[table]
+	if len(ps) < len(columns) {
+		return nil
+	}
+
+	index := make(map[string]int, len(columns))
+	for i, c := range columns {
+		index[c] = i
 	}
+	return &Row{Table: table, columns: columns, index: index, params: ps[:len(columns)]}
 }
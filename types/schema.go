@@ -0,0 +1,267 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/signal"
+)
+
+// Schema tracks the columns of every table created so far in a backup. Signal's database layout
+// changes between versions, so rows are decoded by the column names from the backup's own
+// `CREATE TABLE` statements rather than by fixed positions.
+type Schema struct {
+	tables map[string][]string
+}
+
+// NewSchema returns an empty schema.
+func NewSchema() *Schema {
+	return &Schema{tables: map[string][]string{}}
+}
+
+// Observe records the columns of a `CREATE TABLE` statement. Any other statement is ignored.
+func (s *Schema) Observe(stmt *signal.SqlStatement) error {
+	if !strings.HasPrefix(strings.ToUpper(stmt.GetStatement()), "CREATE TABLE") {
+		return nil
+	}
+	table, columns, err := ParseCreateTable(stmt.GetStatement())
+	if err != nil {
+		return err
+	}
+	s.tables[table] = columns
+	return nil
+}
+
+// Columns returns the columns of a table in the order they were declared, or nil if the table
+// hasn't been created.
+func (s *Schema) Columns(table string) []string {
+	return s.tables[table]
+}
+
+// Row decodes an `INSERT INTO` statement into a row of its table.
+func (s *Schema) Row(stmt *signal.SqlStatement) (*Row, error) {
+	table, columns, err := parseInsert(stmt.GetStatement())
+	if err != nil {
+		return nil, err
+	}
+	if columns == nil {
+		var ok bool
+		if columns, ok = s.tables[table]; !ok {
+			return nil, errors.Errorf("insert into table `%s` before it was created", table)
+		}
+	}
+
+	ps := stmt.GetParameters()
+	if len(ps) != len(columns) {
+		return nil, errors.Errorf("table `%s` has %d columns, but insert has %d values", table, len(columns), len(ps))
+	}
+
+	index := make(map[string]int, len(columns))
+	for i, c := range columns {
+		index[c] = i
+	}
+	return &Row{Table: table, columns: columns, index: index, params: ps}, nil
+}
+
+// MissingColumnError is returned when a row doesn't have the columns needed to decode it.
+type MissingColumnError struct {
+	Table   string
+	Columns []string
+}
+
+func (e *MissingColumnError) Error() string {
+	if len(e.Columns) == 1 {
+		return fmt.Sprintf("table `%s` has no column `%s`", e.Table, e.Columns[0])
+	}
+	return fmt.Sprintf("table `%s` has no columns `%s`", e.Table, strings.Join(e.Columns, "`, `"))
+}
+
+// Row is a single inserted row, with its values accessible by column name.
+//
+// The accessors return nil both for NULL values and for columns the table doesn't have; use Has
+// or Require to tell the two apart.
+type Row struct {
+	Table   string
+	columns []string
+	index   map[string]int
+	params  []*signal.SqlStatement_SqlParameter
+}
+
+// Columns returns the row's columns in the order their values appear in the statement.
+func (r *Row) Columns() []string {
+	return r.columns
+}
+
+// Has reports whether the row's table has a column.
+func (r *Row) Has(column string) bool {
+	_, ok := r.index[column]
+	return ok
+}
+
+// Require returns a MissingColumnError listing every column the row's table doesn't have.
+func (r *Row) Require(columns ...string) error {
+	var missing []string
+	for _, c := range columns {
+		if !r.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	if missing != nil {
+		return &MissingColumnError{Table: r.Table, Columns: missing}
+	}
+	return nil
+}
+
+// Param returns the raw parameter for a column.
+func (r *Row) Param(column string) *signal.SqlStatement_SqlParameter {
+	i, ok := r.index[column]
+	if !ok {
+		return nil
+	}
+	return r.params[i]
+}
+
+// SetInteger changes the value of an integer column, which also changes it in the statement the
+// row was decoded from. Columns the table doesn't have are ignored.
+func (r *Row) SetInteger(column string, v uint64) {
+	if p := r.Param(column); p != nil {
+		*p = signal.SqlStatement_SqlParameter{IntegerParameter: &v}
+	}
+}
+
+// SetString changes the value of a text column, or sets it to NULL if v is nil. Like SetInteger,
+// this also changes the statement, and columns the table doesn't have are ignored.
+func (r *Row) SetString(column string, v *string) {
+	if p := r.Param(column); p != nil {
+		if v == nil {
+			*p = signal.SqlStatement_SqlParameter{Nullparameter: proto.Bool(true)}
+		} else {
+			*p = signal.SqlStatement_SqlParameter{StringParamter: v}
+		}
+	}
+}
+
+// Integer returns the value of an integer column.
+func (r *Row) Integer(column string) *uint64 {
+	if p := r.Param(column); p != nil {
+		return p.IntegerParameter
+	}
+	return nil
+}
+
+// String returns the value of a text column.
+func (r *Row) String(column string) *string {
+	if p := r.Param(column); p != nil {
+		return p.StringParamter
+	}
+	return nil
+}
+
+// Double returns the value of a real column.
+func (r *Row) Double(column string) *float64 {
+	if p := r.Param(column); p != nil {
+		return p.DoubleParameter
+	}
+	return nil
+}
+
+// Blob returns the value of a blob column.
+func (r *Row) Blob(column string) []byte {
+	return r.Param(column).GetBlobParameter()
+}
+
+// ParseCreateTable returns the table name and column names of a `CREATE TABLE` statement. Table
+// constraints such as `PRIMARY KEY (...)` are not columns and are skipped.
+func ParseCreateTable(stmt string) (string, []string, error) {
+	open := strings.Index(stmt, "(")
+	end := strings.LastIndex(stmt, ")")
+	if open < 0 || end < open {
+		return "", nil, errors.Errorf("no column definitions in `%s`", stmt)
+	}
+
+	head := strings.Fields(stmt[:open])
+	if len(head) < 3 {
+		return "", nil, errors.Errorf("no table name in `%s`", stmt)
+	}
+	table := unquoteIdentifier(head[len(head)-1])
+
+	columns := []string{}
+	for _, def := range splitTopLevel(stmt[open+1 : end]) {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		columns = append(columns, unquoteIdentifier(fields[0]))
+	}
+
+	return table, columns, nil
+}
+
+// parseInsert returns the table of an `INSERT INTO` statement, and its column list if it has one.
+func parseInsert(stmt string) (string, []string, error) {
+	const prefix = "INSERT INTO "
+	if !strings.HasPrefix(strings.ToUpper(stmt), prefix) {
+		return "", nil, errors.Errorf("not an insert: `%s`", stmt)
+	}
+	rest := strings.TrimSpace(stmt[len(prefix):])
+
+	nameEnd := strings.IndexAny(rest, " (")
+	if nameEnd < 0 {
+		return "", nil, errors.Errorf("no values in `%s`", stmt)
+	}
+	table := unquoteIdentifier(rest[:nameEnd])
+	rest = strings.TrimSpace(rest[nameEnd:])
+
+	if !strings.HasPrefix(rest, "(") {
+		return table, nil, nil
+	}
+	end := strings.Index(rest, ")")
+	if end < 0 {
+		return "", nil, errors.Errorf("unterminated column list in `%s`", stmt)
+	}
+	columns := []string{}
+	for _, c := range strings.Split(rest[1:end], ",") {
+		columns = append(columns, unquoteIdentifier(strings.TrimSpace(c)))
+	}
+	return table, columns, nil
+}
+
+// splitTopLevel splits a list on commas that aren't nested inside parentheses or quotes.
+func splitTopLevel(s string) []string {
+	var (
+		parts []string
+		depth int
+		quote rune
+		start int
+	)
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteIdentifier(s string) string {
+	return strings.Trim(s, "\"`'[]")
+}
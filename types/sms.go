@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/xeals/signal-back/signal"
 )
 
 // Character sets as specified by IANA.
@@ -142,11 +141,11 @@ type MMSPart struct {
 	Data     *string  `xml:"data,attr"`  // optional
 }
 
-// NewSMSFromStatement constructs an XML SMS struct from a SQL statement.
-func NewSMSFromStatement(stmt *signal.SqlStatement) (*SMS, error) {
-	sms := StatementToSMS(stmt)
-	if sms == nil {
-		return nil, errors.Errorf("expected 22 columns for SMS, have %v", len(stmt.GetParameters()))
+// NewSMSFromRow constructs an XML SMS struct from a row of the sms table.
+func NewSMSFromRow(r *Row) (*SMS, error) {
+	sms, err := RowToSMS(r)
+	if err != nil {
+		return nil, err
 	}
 
 	xml := SMS{
@@ -178,10 +177,15 @@ func NewSMSFromStatement(stmt *signal.SqlStatement) (*SMS, error) {
 	return &xml, nil
 }
 
-func NewMMSFromStatement(stmt *signal.SqlStatement) (uint64, *MMS, error) {
-	mms := StatementToMMS(stmt)
-	if mms == nil {
-		return 0, nil, errors.Errorf("expected at least 42 columns for MMS, have %v", len(stmt.GetParameters()))
+// NewMMSFromRow constructs an XML MMS struct from a row of the mms table, returning it along with
+// its ID.
+func NewMMSFromRow(r *Row) (uint64, *MMS, error) {
+	mms, err := RowToMMS(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if mms.DateSent == nil || mms.DateReceived == nil {
+		return 0, nil, errors.Errorf("MMS %v has no date", mms.ID)
 	}
 
 	xml := MMS{
@@ -309,16 +313,21 @@ func SetMMSMessageType(messageType uint64, mms *MMS) error {
 	return nil
 }
 
-func NewPartFromStatement(stmt *signal.SqlStatement) (uint64, *MMSPart, error) {
-	part := StatementToPart(stmt)
-	if part == nil {
-		return 0, nil, errors.Errorf("expected at least 25 columns for part, have %v", len(stmt.GetParameters()))
+// NewPartFromRow constructs an XML MMS part struct from a row of the part table, returning it
+// along with the ID of the MMS it belongs to.
+func NewPartFromRow(r *Row) (uint64, *MMSPart, error) {
+	part, err := RowToPart(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if part.MmsID == nil {
+		return 0, nil, errors.Errorf("part %v has no MMS", part.RowID)
 	}
 
 	xml := MMSPart{
 		UniqueID: part.UniqueID,
 		Seq:      part.Seq,
-		Ct:       *part.ContentType,
+		Ct:       "null",
 		Name:     "null",
 		ChSet:    CharsetUTF8,
 		Cd:       "null",
@@ -329,6 +338,9 @@ func NewPartFromStatement(stmt *signal.SqlStatement) (uint64, *MMSPart, error) {
 		CttT:     "null",
 	}
 
+	if part.ContentType != nil {
+		xml.Ct = *part.ContentType
+	}
 	if part.Name != nil {
 		xml.Name = *part.Name
 	}
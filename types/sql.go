@@ -0,0 +1,130 @@
+package types
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/signal"
+)
+
+// ParameterValue converts a SQL parameter into the value it should be bound as: a string, int64,
+// float64, []byte or nil. Integers are stored unsigned in the backup but signed in the database.
+func ParameterValue(p *signal.SqlStatement_SqlParameter) interface{} {
+	switch {
+	case p.StringParamter != nil:
+		return *p.StringParamter
+	case p.IntegerParameter != nil:
+		return int64(*p.IntegerParameter)
+	case p.DoubleParameter != nil:
+		return *p.DoubleParameter
+	case p.BlobParameter != nil:
+		return p.BlobParameter
+	default:
+		return nil
+	}
+}
+
+// StatementArgs returns the parameters of a statement as values suitable for binding, such as
+// with database/sql.
+func StatementArgs(s *signal.SqlStatement) []interface{} {
+	args := make([]interface{}, len(s.GetParameters()))
+	for i, p := range s.GetParameters() {
+		args[i] = ParameterValue(p)
+	}
+	return args
+}
+
+// IsInternalStatement reports whether a statement operates on a table that SQLite manages
+// itself, which the Signal app skips when restoring a backup. These are the sqlite_* tables and
+// the shadow tables of full-text search indices, which are recreated along with the index.
+func IsInternalStatement(s *signal.SqlStatement) bool {
+	stmt := strings.ToLower(s.GetStatement())
+	for _, prefix := range []string{"create table ", "insert into ", "drop table if exists ", "drop table "} {
+		if !strings.HasPrefix(stmt, prefix) {
+			continue
+		}
+		fields := strings.Fields(stmt[len(prefix):])
+		if len(fields) == 0 {
+			return false
+		}
+		table := strings.Trim(strings.SplitN(fields[0], "(", 2)[0], "\"`'[]")
+		return strings.HasPrefix(table, "sqlite_") || strings.Contains(table, "_fts_")
+	}
+	return false
+}
+
+// ParameterLiteral formats a SQL parameter as an SQLite literal.
+func ParameterLiteral(p *signal.SqlStatement_SqlParameter) string {
+	switch v := ParameterValue(p).(type) {
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return floatLiteral(v)
+	case []byte:
+		return "X'" + strings.ToUpper(hex.EncodeToString(v)) + "'"
+	default:
+		return "NULL"
+	}
+}
+
+// InlineStatement substitutes the statement's parameters into its placeholders, giving a
+// statement that can be run without binding anything.
+func InlineStatement(s *signal.SqlStatement) (string, error) {
+	var (
+		stmt  = s.GetStatement()
+		ps    = s.GetParameters()
+		out   = make([]byte, 0, len(stmt))
+		n     int
+		quote byte
+	)
+
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '?':
+			if n >= len(ps) {
+				return "", errors.Errorf("statement has more placeholders than its %d parameters", len(ps))
+			}
+			out = append(out, ParameterLiteral(ps[n])...)
+			n++
+			continue
+		}
+		out = append(out, c)
+	}
+
+	if n != len(ps) {
+		return "", errors.Errorf("statement has %d placeholders but %d parameters", n, len(ps))
+	}
+	return string(out), nil
+}
+
+// floatLiteral formats a double so that SQLite reads it back as the same REAL value.
+func floatLiteral(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NULL"
+	case math.IsInf(f, 1):
+		return "9e999"
+	case math.IsInf(f, -1):
+		return "-9e999"
+	}
+
+	lit := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(lit, ".e") {
+		lit += ".0"
+	}
+	return lit
+}
@@ -0,0 +1,185 @@
+package types
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"hash"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/signal"
+)
+
+// SaltLength is the length in bytes of the salt Signal uses when deriving a backup's keys.
+const SaltLength = 32
+
+// BackupWriter encrypts frames and attachments into a backup file, in the same way the Signal app
+// does when exporting a backup.
+//
+// Frames must be written in the order the app expects to import them: a database version first,
+// then statements, preferences and attachments, and an end frame last. Any frame that refers to
+// binary data (an attachment, avatar or sticker) must be followed by a call to EncryptAttachment
+// with that data.
+type BackupWriter struct {
+	w         io.Writer
+	CipherKey []byte
+	MacKey    []byte
+	Mac       hash.Hash
+	IV        []byte
+	Counter   uint32
+	Version   uint32
+}
+
+// NewBackupWriter initialises a backup writer using the provided password, and writes the backup's
+// header to w.
+//
+// If salt or iv are nil, random ones are generated. version is the backup file version to write;
+// MaxBackupVersion is what current versions of the app use, but older versions of the app can only
+// import version 0.
+func NewBackupWriter(w io.Writer, password string, salt, iv []byte, version uint32) (*BackupWriter, error) {
+	if version > MaxBackupVersion {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "version %d is newer than %d", version, MaxBackupVersion)
+	}
+
+	if salt == nil {
+		salt = make([]byte, SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, errors.Wrap(err, "failed to generate salt")
+		}
+	}
+	if iv == nil {
+		iv = make([]byte, 16)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, errors.Wrap(err, "failed to generate IV")
+		}
+	} else if len(iv) != 16 {
+		return nil, errors.Errorf("IV must be 16 bytes, not %d", len(iv))
+	}
+	// The IV is changed with every frame, so don't touch the caller's copy.
+	iv = append([]byte(nil), iv...)
+
+	header := &signal.Header{Iv: iv, Salt: salt}
+	if version > 0 {
+		header.Version = proto.Uint32(version)
+	}
+	headerFrame, err := proto.Marshal(&signal.BackupFrame{Header: header})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode header")
+	}
+
+	headerLength := make([]byte, 4)
+	uint32ToBytes(headerLength, uint32(len(headerFrame)))
+	if _, err = w.Write(headerLength); err != nil {
+		return nil, errors.Wrap(err, "failed to write header")
+	}
+	if _, err = w.Write(headerFrame); err != nil {
+		return nil, errors.Wrap(err, "failed to write header")
+	}
+
+	key := DeriveBackupKey(password, salt)
+
+	return &BackupWriter{
+		w:         w,
+		CipherKey: key.CipherKey,
+		MacKey:    key.MacKey,
+		Mac:       hmac.New(crypto.SHA256.New, key.MacKey),
+		IV:        iv,
+		Counter:   bytesToUint32(iv),
+		Version:   version,
+	}, nil
+}
+
+// WriteFrame encrypts a frame and writes it to the backup.
+func (bw *BackupWriter) WriteFrame(f *signal.BackupFrame) error {
+	plaintext, err := proto.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode frame")
+	}
+
+	stream, err := bw.stream()
+	if err != nil {
+		return err
+	}
+	bw.Mac.Reset()
+
+	length := make([]byte, 4)
+	uint32ToBytes(length, uint32(len(plaintext)+10))
+
+	// Newer backups encrypt the length with the start of the frame's key stream, and include it in
+	// the MAC.
+	if bw.Version >= BackupVersionEncryptedLength {
+		stream.XORKeyStream(length, length)
+		bw.Mac.Write(length)
+	}
+
+	body := make([]byte, len(plaintext))
+	stream.XORKeyStream(body, plaintext)
+	bw.Mac.Write(body)
+
+	for _, b := range [][]byte{length, body, bw.Mac.Sum(nil)[:10]} {
+		if _, err = bw.w.Write(b); err != nil {
+			return errors.Wrap(err, "failed to write frame")
+		}
+	}
+
+	return nil
+}
+
+// EncryptAttachment reads exactly length bytes from in and writes them to the backup as the
+// attachment for the previous frame, using a streaming intermediate buffer of size
+// ATTACHMENT_BUFFER_SIZE.
+func (bw *BackupWriter) EncryptAttachment(length uint32, in io.Reader) error {
+	if length == 0 {
+		return errors.New("can't write attachment of length 0")
+	}
+
+	stream, err := bw.stream()
+	if err != nil {
+		return err
+	}
+	bw.Mac.Reset()
+	bw.Mac.Write(bw.IV)
+
+	buf := make([]byte, ATTACHMENT_BUFFER_SIZE)
+	output := make([]byte, len(buf))
+
+	for length > 0 {
+		n := len(buf)
+		if length < ATTACHMENT_BUFFER_SIZE {
+			n = int(length)
+		}
+		if _, err := io.ReadFull(in, buf[:n]); err != nil {
+			return errors.Wrap(err, "failed to read attachment")
+		}
+
+		stream.XORKeyStream(output[:n], buf[:n])
+		bw.Mac.Write(output[:n])
+		if _, err = bw.w.Write(output[:n]); err != nil {
+			return errors.Wrap(err, "can't write to output")
+		}
+
+		length -= uint32(n)
+	}
+
+	if _, err = bw.w.Write(bw.Mac.Sum(nil)[:10]); err != nil {
+		return errors.Wrap(err, "failed to write attachment MAC")
+	}
+
+	return nil
+}
+
+// stream returns the key stream for the next frame or attachment.
+func (bw *BackupWriter) stream() (cipher.Stream, error) {
+	uint32ToBytes(bw.IV, bw.Counter)
+	bw.Counter++
+
+	aesCipher, err := aes.NewCipher(bw.CipherKey)
+	if err != nil {
+		return nil, errors.New("Bad cipher")
+	}
+	return cipher.NewCTR(aesCipher, bw.IV), nil
+}
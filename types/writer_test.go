@@ -0,0 +1,194 @@
+package types
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/xeals/signal-back/signal"
+)
+
+const testPassword = "123451234512345123451234512345"
+
+// testBackup is a backup written by BackupWriter, along with what was written to it.
+type testBackup struct {
+	data   []byte
+	frames []*signal.BackupFrame
+	// The data written after each frame, by the frame's index.
+	payloads map[int][]byte
+	// Where the data of the first attachment starts, and where the last frame starts.
+	attachmentOffset int
+	lastFrameOffset  int
+}
+
+func writeTestBackup(t *testing.T, version uint32) *testBackup {
+	frames := []*signal.BackupFrame{
+		{Version: &signal.DatabaseVersion{Version: proto.Uint32(42)}},
+		{Statement: &signal.SqlStatement{
+			Statement: proto.String("CREATE TABLE sms (_id INTEGER PRIMARY KEY, body TEXT, date INTEGER, data BLOB, ratio REAL, extra TEXT)"),
+		}},
+		{Statement: &signal.SqlStatement{
+			Statement: proto.String("INSERT INTO sms VALUES (?,?,?,?,?,?)"),
+			Parameters: []*signal.SqlStatement_SqlParameter{
+				{IntegerParameter: proto.Uint64(1)},
+				{StringParamter: proto.String("hello")},
+				{IntegerParameter: proto.Uint64(1546300800000)},
+				{BlobParameter: []byte{0, 1, 2, 3}},
+				{DoubleParameter: proto.Float64(1.5)},
+				{Nullparameter: proto.Bool(true)},
+			},
+		}},
+		{Preference: &signal.SharedPreference{
+			File:  proto.String("org.thoughtcrime.securesms_preferences"),
+			Key:   proto.String("pref_theme"),
+			Value: proto.String("dark"),
+		}},
+		{Attachment: &signal.Attachment{RowId: proto.Uint64(1), AttachmentId: proto.Uint64(1000), Length: proto.Uint32(3000)}},
+		{Avatar: &signal.Avatar{Name: proto.String("+61400000000"), Length: proto.Uint32(100)}},
+		{Sticker: &signal.Sticker{RowId: proto.Uint64(7), Length: proto.Uint32(ATTACHMENT_BUFFER_SIZE + 1)}},
+		{KeyValue: &signal.KeyValue{Key: proto.String("kbs.pin"), StringValue: proto.String("1234")}},
+		{End: proto.Bool(true)},
+	}
+
+	var buf bytes.Buffer
+	bw, err := NewBackupWriter(&buf, testPassword, nil, nil, version)
+	if err != nil {
+		t.Fatalf("NewBackupWriter: %v", err)
+	}
+
+	b := &testBackup{frames: frames, payloads: map[int][]byte{}}
+	for i, f := range frames {
+		if f.GetEnd() {
+			b.lastFrameOffset = buf.Len()
+		}
+		if err = bw.WriteFrame(f); err != nil {
+			t.Fatalf("WriteFrame %d: %v", i, err)
+		}
+
+		length := FrameDataLength(f)
+		if length == 0 {
+			continue
+		}
+		payload := make([]byte, length)
+		for j := range payload {
+			payload[j] = byte(i*31 + j)
+		}
+		b.payloads[i] = payload
+		if b.attachmentOffset == 0 {
+			b.attachmentOffset = buf.Len()
+		}
+		if err = bw.EncryptAttachment(length, bytes.NewReader(payload)); err != nil {
+			t.Fatalf("EncryptAttachment %d: %v", i, err)
+		}
+	}
+
+	b.data = buf.Bytes()
+	return b
+}
+
+// readTestBackup reads every frame and its data from a backup, stopping at the first error.
+func readTestBackup(data []byte) ([]*signal.BackupFrame, map[int][]byte, error) {
+	bf, err := NewBackupFileReader(bytes.NewReader(data), int64(len(data)), testPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bf.Close()
+
+	var frames []*signal.BackupFrame
+	payloads := map[int][]byte{}
+	for {
+		f, err := bf.Frame()
+		if err == io.EOF {
+			return frames, payloads, nil
+		} else if err != nil {
+			return frames, payloads, err
+		}
+		frames = append(frames, f)
+
+		if length := FrameDataLength(f); length > 0 {
+			var out bytes.Buffer
+			if err = bf.DecryptAttachment(length, &out); err != nil {
+				return frames, payloads, err
+			}
+			payloads[len(frames)-1] = out.Bytes()
+		}
+	}
+}
+
+func TestBackupWriterRoundTrip(t *testing.T) {
+	for _, version := range []uint32{0, BackupVersionEncryptedLength} {
+		b := writeTestBackup(t, version)
+
+		frames, payloads, err := readTestBackup(b.data)
+		if err != nil {
+			t.Fatalf("version %d: reading backup: %v", version, err)
+		}
+
+		if len(frames) != len(b.frames) {
+			t.Fatalf("version %d: read %d frames, wrote %d", version, len(frames), len(b.frames))
+		}
+		for i := range frames {
+			if !proto.Equal(frames[i], b.frames[i]) {
+				t.Errorf("version %d: frame %d is %v, wrote %v", version, i, frames[i], b.frames[i])
+			}
+		}
+
+		if len(payloads) != len(b.payloads) {
+			t.Errorf("version %d: read data for %d frames, wrote %d", version, len(payloads), len(b.payloads))
+		}
+		for i, want := range b.payloads {
+			if !bytes.Equal(payloads[i], want) {
+				t.Errorf("version %d: data of frame %d differs from what was written", version, i)
+			}
+		}
+	}
+}
+
+func TestBackupWriterVersion(t *testing.T) {
+	for _, version := range []uint32{0, BackupVersionEncryptedLength} {
+		b := writeTestBackup(t, version)
+
+		bf, err := NewBackupFileReader(bytes.NewReader(b.data), int64(len(b.data)), testPassword)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if bf.Version != version {
+			t.Errorf("wrote version %d, read version %d", version, bf.Version)
+		}
+		bf.Close()
+	}
+}
+
+func TestBackupWriterBadMAC(t *testing.T) {
+	for _, version := range []uint32{0, BackupVersionEncryptedLength} {
+		b := writeTestBackup(t, version)
+
+		for _, tc := range []struct {
+			name   string
+			offset int
+		}{
+			{"attachment", b.attachmentOffset + 100},
+			// Past the length, which fails differently in version 1 backups.
+			{"frame", b.lastFrameOffset + 4},
+		} {
+			data := append([]byte(nil), b.data...)
+			data[tc.offset] ^= 0x01
+
+			_, _, err := readTestBackup(data)
+			if !errors.Is(err, ErrBadMAC) {
+				t.Errorf("version %d: flipped byte in %s: got %v, want ErrBadMAC", version, tc.name, err)
+			}
+		}
+	}
+}
+
+func TestBackupWriterWrongPassword(t *testing.T) {
+	b := writeTestBackup(t, BackupVersionEncryptedLength)
+
+	_, err := NewBackupFileReader(bytes.NewReader(b.data), int64(len(b.data)), "543215432154321543215432154321")
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("got %v, want ErrWrongPassword", err)
+	}
+}